@@ -0,0 +1,135 @@
+package terminal
+
+// charset is a 94 character set that may be designated into G0-G3.
+type charset uint8
+
+const (
+	charsetUSASCII charset = iota
+	charsetDECGraphics
+	charsetUK
+	charsetDECSupplemental
+	charsetDECTechnical
+)
+
+// table from st, which in turn is from rxvt :)
+var gfxCharTable = [62]rune{
+	'↑', '↓', '→', '←', '█', '▚', '☃', // A - G
+	0, 0, 0, 0, 0, 0, 0, 0, // H - O
+	0, 0, 0, 0, 0, 0, 0, 0, // P - W
+	0, 0, 0, 0, 0, 0, 0, ' ', // X - _
+	'◆', '▒', '␉', '␌', '␍', '␊', '°', '±', // ` - g
+	'␤', '␋', '┘', '┐', '┌', '└', '┼', '⎺', // h - o
+	'⎻', '─', '⎼', '⎽', '├', '┤', '┴', '┬', // p - w
+	'│', '≤', '≥', 'π', '≠', '£', '·', // x - ~
+}
+
+// DEC technical character set, from ! to ~
+var techCharTable = [94]rune{
+	'⎷', '┌', '─', '⌠', '⌡', '│', '⎡', '⎣', // ! - (
+	'⎤', '⎦', '⎛', '⎝', '⎞', '⎠', '⎨', '⎬', // ) - 0
+	'⎲', '⎳', '╲', '╱', 0, 0, 0, 0, // 1 - 8
+	0, 0, 0, '≤', '≠', '≥', '∫', '∴', // 9 - @
+	'∝', '∞', '÷', 'Δ', '∇', 'Φ', 'Γ', '∼', // A - H
+	'≃', 'Θ', '×', 'Λ', '⇔', '⇒', '≡', 'Π', // I - P
+	'Ψ', 0, 'Σ', 0, 0, '√', 'Ω', 'Ξ', // Q - X
+	'Υ', '⊂', '⊃', '∩', '∪', '∧', '∨', '¬', // Y - `
+	'α', 'β', 'χ', 'δ', 'ε', 'φ', 'γ', 'η', // a - h
+	'ι', 'θ', 'κ', 'λ', 0, 'ν', '∂', 'π', // i - p
+	'ψ', 'ρ', 'σ', 'τ', 0, 'ƒ', 'ω', 'ξ', // q - x
+	'υ', 'ζ', '←', '↑', '→', '↓', // y - ~
+}
+
+// mapRune translates c, a character in [0x20, 0x7f), from the charset.
+func (cs charset) mapRune(c rune) rune {
+	switch cs {
+	case charsetDECGraphics:
+		if c >= 0x41 && gfxCharTable[c-0x41] != 0 {
+			return gfxCharTable[c-0x41]
+		}
+	case charsetUK:
+		if c == '#' {
+			return '£'
+		}
+	case charsetDECSupplemental:
+		// mostly the upper half of latin-1
+		switch c {
+		case ' ':
+		case '(':
+			return '¤'
+		case 'W':
+			return 'Œ'
+		case ']':
+			return 'Ÿ'
+		case 'w':
+			return 'œ'
+		case '}':
+			return 'ÿ'
+		default:
+			return c + 0x80
+		}
+	case charsetDECTechnical:
+		if c > ' ' && techCharTable[c-0x21] != 0 {
+			return techCharTable[c-0x21]
+		}
+	}
+	return c
+}
+
+// translate maps c through the charset invoked into GL, or the one
+// selected by a pending single shift.
+func (t *State) translate(c rune) rune {
+	g := t.cur.gl
+	if t.singleShift != 0 {
+		g = t.singleShift
+		t.singleShift = 0
+	}
+	if c < 0x20 || c >= 0x7f {
+		return c
+	}
+	return t.cur.charsets[g].mapRune(c)
+}
+
+// designate sets the charset of G0-G3 from the final character of a SCS
+// escape sequence. Pct is true for the two character designators starting
+// with '%'.
+func (t *State) designate(g int, c rune, pct bool) {
+	cs := charsetUSASCII
+	if pct {
+		switch c {
+		case '5': // DEC supplemental graphic
+			cs = charsetDECSupplemental
+		case '0', // Turkish (ignored)
+			'2', // Turkish (ignored)
+			'6', // Portuguese (ignored)
+			'=': // Hebrew (ignored)
+		default:
+			t.logf("unknown charset '%%%c'\n", c)
+		}
+		t.cur.charsets[g] = cs
+		return
+	}
+	switch c {
+	case '0': // DEC special graphics, line drawing set
+		cs = charsetDECGraphics
+	case 'A': // UK
+		cs = charsetUK
+	case '<': // DEC supplemental
+		cs = charsetDECSupplemental
+	case '>': // DEC technical
+		cs = charsetDECTechnical
+	case 'B', // USASCII
+		'4', // Dutch (ignored)
+		'5', // Finnish (ignored)
+		'C', // Finnish (ignored)
+		'K', // German (ignored)
+		'R', // French (ignored)
+		'Q', // French Canadian (ignored)
+		'Y', // Italian (ignored)
+		'Z', // Spanish (ignored)
+		'7', // Swedish (ignored)
+		'=': // Swiss (ignored)
+	default:
+		t.logf("unknown charset '%c'\n", c)
+	}
+	t.cur.charsets[g] = cs
+}
@@ -0,0 +1,61 @@
+package terminal
+
+import (
+	"encoding/base64"
+)
+
+// Clipboard is implemented by frontends to handle OSC 52 clipboard
+// requests. Selections are named as by xterm: 'c' for the clipboard, 'p'
+// for the primary selection, 's' for the secondary selection, and '0' to
+// '7' for the cut buffers. Methods are called with the State locked.
+type Clipboard interface {
+	// SetClipboard sets the contents of a selection. Empty data clears
+	// the selection.
+	SetClipboard(sel byte, data []byte)
+
+	// GetClipboard returns the contents of a selection. It is only called
+	// if State.ClipboardRead is set.
+	GetClipboard(sel byte) ([]byte, error)
+}
+
+// handleClipboard handles OSC 52 with the selections and data arguments.
+func (t *State) handleClipboard(sels, data string) {
+	if t.Clipboard == nil {
+		return
+	}
+	if t.str.overflow {
+		t.logln("clipboard data too large")
+		return
+	}
+	if sels == "" {
+		sels = "c"
+	}
+	if data == "?" {
+		if !t.ClipboardRead {
+			t.logln("clipboard read denied")
+			return
+		}
+		sel := sels[0]
+		b, err := t.Clipboard.GetClipboard(sel)
+		if err != nil {
+			t.logf("clipboard read failed: %v\n", err)
+			return
+		}
+		t.respond("\033]52;%c;%s%s", sel,
+			base64.StdEncoding.EncodeToString(b), t.str.term)
+		return
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		// xterm clears the selection on bad data
+		b = nil
+	}
+	for i := 0; i < len(sels); i++ {
+		switch sel := sels[i]; sel {
+		case 'c', 'p', 'q', 's', '0', '1', '2', '3', '4', '5', '6', '7':
+			t.Clipboard.SetClipboard(sel, b)
+		default:
+			t.logf("unknown selection '%c'\n", sel)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package terminal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+)
+
+type testClipboard map[byte]string
+
+func (c testClipboard) SetClipboard(sel byte, data []byte) {
+	c[sel] = string(data)
+}
+
+func (c testClipboard) GetClipboard(sel byte) ([]byte, error) {
+	return []byte(c[sel]), nil
+}
+
+func TestClipboard(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	term.SetResponseWriter(&buf)
+	clip := testClipboard{}
+	st.Clipboard = clip
+
+	// a payload well past the old 256 rune limit
+	text := strings.Repeat("hello clipboard ", 100)
+	_, err = term.Write([]byte("\033]52;cp;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a\033]52;c;?\a"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if clip['c'] != text || clip['p'] != text {
+		t.Fatal(clip)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("read allowed: %q", buf.String())
+	}
+
+	st.ClipboardRead = true
+	_, err = term.Write([]byte("\033]52;p;?\033\\"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if buf.String() != "\033]52;p;"+base64.StdEncoding.EncodeToString([]byte(text))+"\033\\" {
+		t.Fatalf("%q", buf.String())
+	}
+}
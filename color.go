@@ -28,10 +28,36 @@ const (
 	DefaultBG
 )
 
-// Color maps to the ANSI colors [0, 16) and the xterm colors [16, 256).
-type Color uint16
+// colorRGB marks a Color as holding a direct 24-bit RGB value in its
+// lower bits, as set by SGR 38;2 and 48;2.
+const colorRGB Color = 1 << 24
+
+// Color maps to the ANSI colors [0, 16), the xterm colors [16, 256), the
+// default colors, or a direct 24-bit RGB value.
+type Color uint32
+
+// RGB returns a Color holding a direct 24-bit RGB value.
+func RGB(r, g, b uint8) Color {
+	return colorRGB | Color(r)<<16 | Color(g)<<8 | Color(b)
+}
 
 // ANSI returns true if Color is within [0, 16).
 func (c Color) ANSI() bool {
 	return (c < 16)
 }
+
+// Indexed returns true if Color is within [0, 256).
+func (c Color) Indexed() bool {
+	return (c < 256)
+}
+
+// TrueColor returns true if Color holds a direct 24-bit RGB value.
+func (c Color) TrueColor() bool {
+	return c&colorRGB != 0
+}
+
+// RGB returns the red, green, and blue components of a true color. The
+// result is meaningless unless TrueColor returns true.
+func (c Color) RGB() (r, g, b uint8) {
+	return uint8(c >> 16), uint8(c >> 8), uint8(c)
+}
@@ -8,10 +8,12 @@ import (
 // CSI (Control Sequence Introducer)
 // ESC+[
 type csiEscape struct {
-	buf  []byte
-	args []int
-	mode byte
-	priv bool
+	buf    []byte
+	args   []int
+	mode   byte
+	priv   bool
+	prefix byte // private parameter prefix; one of '<', '=', '>', '?'
+	inter  byte // intermediate byte, such as '$'
 }
 
 func (c *csiEscape) reset() {
@@ -19,6 +21,8 @@ func (c *csiEscape) reset() {
 	c.args = c.args[:0]
 	c.mode = 0
 	c.priv = false
+	c.prefix = 0
+	c.inter = 0
 }
 
 func (c *csiEscape) put(b byte) bool {
@@ -37,11 +41,17 @@ func (c *csiEscape) parse() {
 	}
 	s := string(c.buf)
 	c.args = c.args[:0]
-	if s[0] == '?' {
-		c.priv = true
+	switch s[0] {
+	case '<', '=', '>', '?':
+		c.prefix = s[0]
+		c.priv = s[0] == '?'
 		s = s[1:]
 	}
 	s = s[:len(s)-1]
+	for len(s) > 0 && s[len(s)-1] >= 0x20 && s[len(s)-1] <= 0x2f {
+		c.inter = s[len(s)-1]
+		s = s[:len(s)-1]
+	}
 	ss := strings.Split(s, ";")
 	for _, p := range ss {
 		i, err := strconv.Atoi(p)
@@ -67,6 +77,10 @@ func (c *csiEscape) maxarg(i, def int) int {
 
 func (t *State) handleCSI() {
 	c := &t.csi
+	if c.inter != 0 {
+		t.handleCSIInter()
+		return
+	}
 	switch c.mode {
 	default:
 		goto unknown
@@ -77,8 +91,16 @@ func (t *State) handleCSI() {
 	case 'B', 'e': // CUD, VPR - cursor <n> down
 		t.moveTo(t.cur.x, t.cur.y+c.maxarg(0, 1))
 	case 'c': // DA - device attributes
-		if c.arg(0, 0) == 0 {
-			// TODO: write vt102 id
+		if c.arg(0, 0) != 0 {
+			break
+		}
+		switch c.prefix {
+		case 0: // primary
+			t.respond(deviceAttrs)
+		case '>': // secondary
+			t.respond("\033[>1;0;0c")
+		default:
+			goto unknown
 		}
 	case 'C', 'a': // CUF, HPR - cursor <n> forward
 		t.moveTo(t.cur.x+c.maxarg(0, 1), t.cur.y)
@@ -118,13 +140,18 @@ func (t *State) handleCSI() {
 			if t.cur.y < t.rows-1 {
 				t.clear(0, t.cur.y+1, t.cols-1, t.rows-1)
 			}
+			t.dropMarks(t.cur.y+1, t.rows-1)
 		case 1: // above
 			if t.cur.y > 1 {
 				t.clear(0, 0, t.cols-1, t.cur.y-1)
 			}
 			t.clear(0, t.cur.y, t.cur.x, t.cur.y)
+			t.dropMarks(0, t.cur.y-1)
 		case 2: // all
 			t.clear(0, 0, t.cols-1, t.rows-1)
+			t.dropMarks(0, t.rows-1)
+		case 3: // scrollback
+			t.clearHistory()
 		default:
 			goto unknown
 		}
@@ -144,6 +171,9 @@ func (t *State) handleCSI() {
 	case 'L': // IL - insert <n> blank lines
 		t.insertBlankLines(c.arg(0, 1))
 	case 'l': // RM - reset mode
+		if c.prefix != 0 && !c.priv {
+			goto unknown
+		}
 		t.setMode(c.priv, false, c.args)
 	case 'M': // DL - delete <n> lines
 		t.deleteLines(c.arg(0, 1))
@@ -159,9 +189,54 @@ func (t *State) handleCSI() {
 	case 'd': // VPA - move to <row>
 		t.moveAbsTo(t.cur.x, c.arg(0, 1)-1)
 	case 'h': // SM - set terminal mode
+		if c.prefix != 0 && !c.priv {
+			goto unknown
+		}
 		t.setMode(c.priv, true, c.args)
 	case 'm': // SGR - terminal attribute (color)
+		if c.prefix == '>' {
+			// XTMODKEYS - set key modifier options
+			t.setKeyModifierOptions(c.arg(0, -1), c.arg(1, 0))
+			break
+		}
+		if c.prefix != 0 {
+			goto unknown
+		}
 		t.setAttr(c.args)
+	case 'n': // DSR - device status report
+		if c.prefix == '>' {
+			// XTMODKEYS - disable key modifier options
+			t.setKeyModifierOptions(c.arg(0, -1), 0)
+			break
+		}
+		switch c.arg(0, 0) {
+		case 5: // operating status
+			if c.prefix != 0 {
+				goto unknown
+			}
+			t.respond("\033[0n")
+		case 6: // CPR - cursor position report
+			x, y := t.cur.x+1, t.cur.y+1
+			if t.cur.state&cursorOrigin != 0 {
+				x -= t.left
+				y -= t.top
+			}
+			switch c.prefix {
+			case 0:
+				t.respond("\033[%d;%dR", y, x)
+			case '?': // DECXCPR - extended cursor position
+				t.respond("\033[?%d;%d;1R", y, x)
+			default:
+				goto unknown
+			}
+		case 15: // printer status
+			if !c.priv {
+				goto unknown
+			}
+			t.respond("\033[?13n")
+		default:
+			goto unknown
+		}
 	case 'r': // DECSTBM - set scrolling region
 		if c.priv {
 			goto unknown
@@ -169,13 +244,98 @@ func (t *State) handleCSI() {
 			t.setScroll(c.arg(0, 1)-1, c.arg(1, t.rows)-1)
 			t.moveAbsTo(0, 0)
 		}
-	case 's': // DECSC - save cursor position (ANSI.SYS)
-		t.saveCursor()
-	case 'u': // DECRC - restore cursor position (ANSI.SYS)
-		t.restoreCursor()
+	case 's':
+		if c.prefix != 0 {
+			goto unknown
+		} else if t.mode&ModeLeftRightMargin != 0 {
+			// DECSLRM - set left and right margins
+			t.setMargins(c.arg(0, 1)-1, c.arg(1, t.cols)-1)
+			t.moveAbsTo(0, 0)
+		} else {
+			// DECSC - save cursor position (ANSI.SYS)
+			t.saveCursor()
+		}
+	case 'u':
+		switch c.prefix {
+		case 0: // DECRC - restore cursor position (ANSI.SYS)
+			t.restoreCursor()
+		case '>': // push keyboard flags
+			t.pushKeyboardFlags(c.arg(0, 0))
+		case '<': // pop keyboard flags
+			t.popKeyboardFlags(c.arg(0, 1))
+		case '=': // set keyboard flags
+			t.setKeyboardFlags(c.arg(0, 0), c.arg(1, 1))
+		case '?': // query keyboard flags
+			t.respond("\033[?%du", t.keyboardFlags())
+		}
 	}
 	return
 unknown: // TODO: get rid of this goto
 	t.logf("unknown CSI sequence '%c'\n", c.mode)
 	// TODO: c.dump()
 }
+
+// handleCSIInter handles CSI sequences with an intermediate byte.
+func (t *State) handleCSIInter() {
+	c := &t.csi
+	switch c.inter {
+	case '$':
+		switch c.mode {
+		case 'v': // DECCRA - copy rectangular area
+			t.copyRect()
+			return
+		case 'x': // DECFRA - fill rectangular area
+			t.fillRect(rune(c.arg(0, 0)))
+			return
+		case 'z': // DECERA - erase rectangular area
+			if x0, y0, x1, y1, ok := t.rectArgs(0, false); ok {
+				t.clear(x0, y0, x1, y1)
+			}
+			return
+		case '{': // DECSERA - selective erase rectangular area
+			t.selectiveEraseRect()
+			return
+		case 'r': // DECCARA - change attributes in rectangular area
+			t.changeRectAttrs(false)
+			return
+		case 't': // DECRARA - reverse attributes in rectangular area
+			t.changeRectAttrs(true)
+			return
+		case 'p': // DECRQM - request mode
+			if c.prefix != 0 && !c.priv {
+				break
+			}
+			t.reportMode(c.priv, c.arg(0, 0))
+			return
+		}
+	case '*':
+		switch c.mode {
+		case 'x': // DECSACE - select attribute change extent
+			t.rectExtent = c.arg(0, 0) == 2
+			return
+		}
+	case ' ':
+		switch c.mode {
+		case 'q': // DECSCUSR - set cursor style
+			t.setCursorStyle(c.arg(0, 0))
+			return
+		}
+	case '!':
+		switch c.mode {
+		case 'p': // DECSTR - soft terminal reset
+			t.softReset()
+			return
+		}
+	case '"':
+		switch c.mode {
+		case 'q': // DECSCA - select character protection attribute
+			if c.arg(0, 0) == 1 {
+				t.cur.attr.mode |= attrProtected
+			} else {
+				t.cur.attr.mode &^= attrProtected
+			}
+			return
+		}
+	}
+	t.logf("unknown CSI sequence '%c%c'\n", c.inter, c.mode)
+}
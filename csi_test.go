@@ -33,4 +33,18 @@ func TestCSIParse(t *testing.T) {
 	if csi.mode != 'l' || csi.arg(0, 0) != 25 || csi.priv != true || len(csi.args) != 1 {
 		t.Fatal("CSI parse mismatch")
 	}
+
+	csi.reset()
+	csi.buf = []byte(">c")
+	csi.parse()
+	if csi.mode != 'c' || csi.prefix != '>' || csi.priv != false || len(csi.args) != 0 {
+		t.Fatal("CSI parse mismatch")
+	}
+
+	csi.reset()
+	csi.buf = []byte("1;2;3;4$z")
+	csi.parse()
+	if csi.mode != 'z' || csi.inter != '$' || csi.arg(3, 0) != 4 || len(csi.args) != 4 {
+		t.Fatal("CSI parse mismatch")
+	}
 }
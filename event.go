@@ -0,0 +1,88 @@
+package terminal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Event is a notification from the application for the frontend, passed
+// to State.EventHandler. It is one of BellEvent, NotifyEvent, or
+// ProgressEvent.
+type Event interface {
+	isEvent()
+}
+
+// BellEvent is sent for BEL.
+type BellEvent struct{}
+
+// NotifyEvent is a desktop notification request, sent for OSC 9 and
+// OSC 777.
+type NotifyEvent struct {
+	Title string
+	Body  string
+}
+
+// ProgressState is the state of a progress indicator.
+type ProgressState int
+
+// Progress states, as numbered by OSC 9;4
+const (
+	ProgressNone ProgressState = iota
+	ProgressNormal
+	ProgressError
+	ProgressIndeterminate
+	ProgressPaused
+)
+
+// ProgressEvent reports the progress of a long running task, sent for
+// OSC 9;4. Value is a percentage.
+type ProgressEvent struct {
+	State ProgressState
+	Value int
+}
+
+func (BellEvent) isEvent()     {}
+func (NotifyEvent) isEvent()   {}
+func (ProgressEvent) isEvent() {}
+
+func (t *State) emit(ev Event) {
+	if t.EventHandler != nil {
+		t.EventHandler(ev)
+	}
+}
+
+// handleNotify handles OSC 9, which is either an iTerm2 style notification
+// or, following ConEmu, a progress report.
+func (t *State) handleNotify(args []string) {
+	if len(args) >= 2 && args[0] == "4" {
+		st, err := strconv.Atoi(args[1])
+		if err != nil || !between(st, 0, 4) {
+			t.logf("bad progress state '%s'\n", args[1])
+			return
+		}
+		ev := ProgressEvent{State: ProgressState(st)}
+		if len(args) >= 3 {
+			v, _ := strconv.Atoi(args[2])
+			ev.Value = clamp(v, 0, 100)
+		}
+		t.emit(ev)
+		return
+	}
+	t.emit(NotifyEvent{Body: strings.Join(args, ";")})
+}
+
+// handleRxvtExtension handles OSC 777, of which only notify is supported.
+func (t *State) handleRxvtExtension(args []string) {
+	if len(args) == 0 || args[0] != "notify" {
+		t.logln("unknown OSC 777 extension")
+		return
+	}
+	var ev NotifyEvent
+	if len(args) > 1 {
+		ev.Title = args[1]
+	}
+	if len(args) > 2 {
+		ev.Body = strings.Join(args[2:], ";")
+	}
+	t.emit(ev)
+}
@@ -0,0 +1,37 @@
+package terminal
+
+import (
+	"io"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	st.EventHandler = func(ev Event) {
+		events = append(events, ev)
+	}
+	_, err = term.Write([]byte("\a\033]9;done; ok\a\033]777;notify;build;finished\033\\\033]9;4;1;42\a\033]9;4;0\a"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	expected := []Event{
+		BellEvent{},
+		NotifyEvent{Body: "done; ok"},
+		NotifyEvent{Title: "build", Body: "finished"},
+		ProgressEvent{ProgressNormal, 42},
+		ProgressEvent{ProgressNone, 0},
+	}
+	if len(events) != len(expected) {
+		t.Fatal(events)
+	}
+	for i := range events {
+		if events[i] != expected[i] {
+			t.Fatal(i, events[i])
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package terminal
+
+// SetHistoryLimit sets the maximum number of lines kept in the scrollback
+// history of the primary screen. A limit of zero, the default, disables
+// scrollback. Lowering the limit discards the oldest lines.
+func (t *State) SetHistoryLimit(n int) {
+	if n < 0 {
+		n = 0
+	}
+	t.historyLimit = n
+	t.trimHistory()
+}
+
+// HistoryLimit returns the maximum number of scrollback lines.
+func (t *State) HistoryLimit() int {
+	return t.historyLimit
+}
+
+// HistoryLen returns the number of lines in the scrollback history.
+func (t *State) HistoryLen() int {
+	return len(t.history)
+}
+
+// HistoryCell returns the character code, foreground color, and background
+// color at position (x, y) of the scrollback history, where y is in
+// [0, HistoryLen) and 0 is the oldest line.
+func (t *State) HistoryCell(x, y int) (ch rune, fg Color, bg Color) {
+	g := t.historyGlyph(x, y)
+	return g.c, g.fg, g.bg
+}
+
+// HistoryCellString is like CellString, but for the scrollback history.
+func (t *State) HistoryCellString(x, y int) string {
+	l := &t.history[y]
+	if x >= len(l.cells) {
+		return " "
+	}
+	return l.cellString(x)
+}
+
+// SetViewOffset scrolls the viewport n lines back into the scrollback
+// history. An offset of zero shows the screen as is.
+func (t *State) SetViewOffset(n int) {
+	n = clamp(n, 0, len(t.history))
+	if n != t.viewOffset {
+		t.viewOffset = n
+		t.dirtyAll()
+	}
+}
+
+// ViewOffset returns the number of lines the viewport is scrolled back.
+func (t *State) ViewOffset() int {
+	return t.viewOffset
+}
+
+// ViewCell is like Cell, but relative to the top left of the viewport as
+// set by SetViewOffset.
+func (t *State) ViewCell(x, y int) (ch rune, fg Color, bg Color) {
+	if y < t.viewOffset {
+		return t.HistoryCell(x, len(t.history)-t.viewOffset+y)
+	}
+	return t.Cell(x, y-t.viewOffset)
+}
+
+// ViewCellString is like CellString, but relative to the viewport.
+func (t *State) ViewCellString(x, y int) string {
+	if y < t.viewOffset {
+		return t.HistoryCellString(x, len(t.history)-t.viewOffset+y)
+	}
+	return t.CellString(x, y-t.viewOffset)
+}
+
+func (t *State) historyGlyph(x, y int) glyph {
+	l := t.history[y].cells
+	if x >= len(l) {
+		return glyph{c: ' ', fg: DefaultFG, bg: DefaultBG}
+	}
+	return l[x]
+}
+
+// pushHistory appends a copy of l, a line of the primary screen, to the
+// scrollback history.
+func (t *State) pushHistory(l line) {
+	if t.historyLimit == 0 {
+		return
+	}
+	t.history = append(t.history, l.clone())
+	if t.viewOffset > 0 {
+		t.viewOffset++
+	}
+	t.trimHistory()
+}
+
+func (t *State) trimHistory() {
+	if n := len(t.history) - t.historyLimit; n > 0 {
+		for i := 0; i < n; i++ {
+			t.history[i] = line{}
+		}
+		t.history = t.history[n:]
+	}
+	t.viewOffset = min(t.viewOffset, len(t.history))
+}
+
+func (t *State) clearHistory() {
+	t.history = nil
+	if t.viewOffset > 0 {
+		t.viewOffset = 0
+		t.dirtyAll()
+	}
+}
@@ -0,0 +1,52 @@
+package terminal
+
+import (
+	"io"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetHistoryLimit(3)
+	term.Resize(10, 2)
+	_, err = term.Write([]byte("a\r\nb\r\nc\r\nd\r\ne\r\nf"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if st.HistoryLen() != 3 {
+		t.Fatal(st.HistoryLen())
+	}
+	for i, expected := range "bcd" {
+		if c, _, _ := st.HistoryCell(0, i); c != expected {
+			t.Fatal(i, string(c))
+		}
+	}
+	st.SetViewOffset(1)
+	if c, _, _ := st.ViewCell(0, 0); c != 'd' {
+		t.Fatal(string(c))
+	}
+	if c, _, _ := st.ViewCell(0, 1); c != 'e' {
+		t.Fatal(string(c))
+	}
+
+	// the alternate screen does not scroll into history
+	_, err = term.Write([]byte("\033[?1049h\r\n\r\n\r\n"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if c, _, _ := st.HistoryCell(0, 2); c != 'd' || st.ViewOffset() != 1 {
+		t.Fatal(string(c), st.ViewOffset())
+	}
+
+	_, err = term.Write([]byte("\033[3J"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if st.HistoryLen() != 0 || st.ViewOffset() != 0 {
+		t.Fatal(st.HistoryLen(), st.ViewOffset())
+	}
+}
@@ -0,0 +1,134 @@
+package terminal
+
+import "image"
+
+// ImagePlacement is an image displayed over a region of cells.
+type ImagePlacement struct {
+	Image image.Image
+
+	// X and Y are the cell of the top left corner of the image, which may
+	// be off screen if it has been scrolled or clipped.
+	X, Y int
+
+	// Cols and Rows are the number of cells the image is scaled to.
+	Cols, Rows int
+}
+
+type imageInfo struct {
+	img        image.Image
+	cols, rows int
+}
+
+// minImageGC is the number of placed images before those no longer on any
+// cell are dropped.
+const minImageGC = 16
+
+// Images returns the images with at least one cell on the screen, from
+// top left to bottom right. Cells overwritten by text no longer show the
+// image; see CellImage. Images are at most 4096x4096 pixels, and iTerm2
+// inline image sequences at most 64 MiB, including the base64 encoding;
+// larger ones are dropped.
+func (t *State) Images() []ImagePlacement {
+	var imgs []ImagePlacement
+	seen := make(map[uint32]bool)
+	for y := range t.lines {
+		l := &t.lines[y]
+		if len(l.extras) == 0 {
+			continue
+		}
+		for x := range l.cells {
+			e := l.ext(x)
+			if e.img == 0 || seen[e.img] {
+				continue
+			}
+			seen[e.img] = true
+			info := t.images[e.img]
+			if info == nil {
+				continue
+			}
+			imgs = append(imgs, ImagePlacement{
+				Image: info.img,
+				X:     x - int(e.imgX),
+				Y:     y - int(e.imgY),
+				Cols:  info.cols,
+				Rows:  info.rows,
+			})
+		}
+	}
+	return imgs
+}
+
+// CellImage returns the image covering position (x, y), if any, along with
+// the cell's column and row within the image.
+func (t *State) CellImage(x, y int) (img image.Image, col, row int, ok bool) {
+	e := t.lines[y].ext(x)
+	info := t.images[e.img]
+	if e.img == 0 || info == nil {
+		return nil, 0, 0, false
+	}
+	return info.img, int(e.imgX), int(e.imgY), true
+}
+
+// placeImage displays img over cols by rows cells from the cursor, scrolling
+// as needed. The cursor is left on the last row of the image.
+func (t *State) placeImage(img image.Image, cols, rows int) {
+	if t.images == nil {
+		t.images = make(map[uint32]*imageInfo)
+	} else if len(t.images) >= max(t.imageGC, minImageGC) {
+		t.collectImages()
+	}
+	t.nextImage++
+	id := t.nextImage
+	t.images[id] = &imageInfo{img, cols, rows}
+
+	x0 := t.cur.x
+	for row := 0; row < rows; row++ {
+		if row > 0 {
+			t.newline(false)
+		}
+		for col := 0; col < cols && x0+col < t.cols; col++ {
+			t.setChar(' ', &t.cur.attr, x0+col, t.cur.y)
+			l := &t.lines[t.cur.y]
+			e := l.ext(x0 + col)
+			e.img = id
+			e.imgX = uint16(col)
+			e.imgY = uint16(row)
+			l.setExt(x0+col, e)
+		}
+	}
+}
+
+// fitScreen scales a size of cols by rows cells down to fit on the screen,
+// keeping its aspect ratio.
+func (t *State) fitScreen(cols, rows int) (int, int) {
+	if cols > t.cols {
+		rows = max(rows*t.cols/cols, 1)
+		cols = t.cols
+	}
+	if rows > t.rows {
+		cols = max(cols*t.rows/rows, 1)
+		rows = t.rows
+	}
+	return cols, rows
+}
+
+// collectImages drops images no longer on any cell.
+func (t *State) collectImages() {
+	live := make(map[uint32]bool)
+	t.eachExt(func(e *cellExt) {
+		live[e.img] = true
+	})
+	for id := range t.images {
+		if !live[id] {
+			delete(t.images, id)
+		}
+	}
+	keep := t.kittyPlacements[:0]
+	for _, kp := range t.kittyPlacements {
+		if t.images[kp.id] != nil {
+			keep = append(keep, kp)
+		}
+	}
+	t.kittyPlacements = keep
+	t.imageGC = 2 * len(t.images)
+}
@@ -25,8 +25,8 @@ func (t *VT) ptyResize() error {
 	var w struct{ row, col, xpix, ypix uint16 }
 	w.row = uint16(t.dest.rows)
 	w.col = uint16(t.dest.cols)
-	w.xpix = 16 * uint16(t.dest.cols)
-	w.ypix = 16 * uint16(t.dest.rows)
+	w.xpix = uint16(t.dest.cellW * t.dest.cols)
+	w.ypix = uint16(t.dest.cellH * t.dest.rows)
 	return ioctl(t.pty, syscall.TIOCSWINSZ,
 		uintptr(unsafe.Pointer(&w)))
 }
@@ -0,0 +1,139 @@
+package terminal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	_ "image/gif" // inline image formats
+	_ "image/jpeg"
+	_ "image/png"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// UserVars returns a copy of the user variables set by the application
+// with the iTerm2 SetUserVar sequence.
+func (t *State) UserVars() map[string]string {
+	vars := make(map[string]string, len(t.userVars))
+	for k, v := range t.userVars {
+		vars[k] = v
+	}
+	return vars
+}
+
+// handleITerm handles OSC 1337 with its argument, as sent.
+func (t *State) handleITerm(arg string) {
+	key, val, _ := strings.Cut(arg, "=")
+	switch key {
+	case "File":
+		t.handleITermFile(val)
+	case "SetUserVar":
+		name, v, _ := strings.Cut(val, "=")
+		data, err := base64.StdEncoding.DecodeString(v)
+		if name == "" || err != nil {
+			t.logf("invalid SetUserVar '%s'\n", val)
+			return
+		}
+		if t.userVars == nil {
+			t.userVars = make(map[string]string)
+		}
+		t.userVars[name] = string(data)
+		t.changed |= ChangedUserVars
+	case "CurrentDir":
+		t.setWorkingDirectory((&url.URL{Scheme: "file", Path: val}).String())
+	default:
+		t.logf("unknown iTerm2 command '%s'\n", key)
+	}
+}
+
+// handleITermFile handles File=args:data, displaying inline images at the
+// cursor. Downloads (inline=0) are not supported.
+func (t *State) handleITermFile(s string) {
+	s, payload, ok := strings.Cut(s, ":")
+	if !ok || t.str.overflow {
+		t.logln("invalid iTerm2 file")
+		return
+	}
+	args := make(map[string]string)
+	for _, kv := range strings.Split(s, ";") {
+		k, v, _ := strings.Cut(kv, "=")
+		args[k] = v
+	}
+	if args["inline"] != "1" {
+		t.logln("iTerm2 file download not supported")
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		t.logf("invalid iTerm2 file: %v\n", err)
+		return
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil && (cfg.Width > maxSixelSize || cfg.Height > maxSixelSize) {
+		t.logf("iTerm2 image too large: %dx%d\n", cfg.Width, cfg.Height)
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.logf("invalid iTerm2 image: %v\n", err)
+		return
+	}
+	b := img.Bounds()
+	if b.Empty() {
+		return
+	}
+
+	// the size in pixels, where 0 is auto
+	w := t.itermSize(args["width"], t.cellW, t.cols)
+	h := t.itermSize(args["height"], t.cellH, t.rows)
+	if args["preserveAspectRatio"] != "0" {
+		switch {
+		case w == 0 && h == 0:
+		case h == 0:
+			h = w * b.Dy() / b.Dx()
+		case w == 0:
+			w = h * b.Dx() / b.Dy()
+		default:
+			// fit within the box
+			if w*b.Dy() < h*b.Dx() {
+				h = w * b.Dy() / b.Dx()
+			} else {
+				w = h * b.Dx() / b.Dy()
+			}
+		}
+	}
+	if w == 0 {
+		w = b.Dx()
+	}
+	if h == 0 {
+		h = b.Dy()
+	}
+	cols := max((w+t.cellW-1)/t.cellW, 1)
+	rows := max((h+t.cellH-1)/t.cellH, 1)
+	cols, rows = t.fitScreen(cols, rows)
+	t.placeImage(img, cols, rows)
+	t.newline(true)
+}
+
+// itermSize returns the size in pixels of an iTerm2 width or height given
+// as N cells, Npx, N% of the screen, or auto (0).
+func (t *State) itermSize(s string, cell, cells int) int {
+	unit := cell
+	switch {
+	case strings.HasSuffix(s, "px"):
+		s, unit = s[:len(s)-2], 1
+	case strings.HasSuffix(s, "%"):
+		s = s[:len(s)-1]
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0
+		}
+		return min(n, 100) * cell * cells / 100
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return min(n, maxSixelSize) * unit
+}
@@ -0,0 +1,122 @@
+package terminal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestITermInlineImage(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.SetCellSize(2, 4)
+	term.Resize(10, 6)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 8, 4))); err != nil {
+		t.Fatal(err)
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	tests := []struct {
+		args       string
+		cols, rows int
+	}{
+		{"inline=1", 4, 1},
+		{"inline=1;width=6", 6, 2},
+		{"inline=1;height=8px", 8, 2},
+		{"inline=1;width=50%;height=2;preserveAspectRatio=0", 5, 2},
+		{"inline=1;width=8;height=1", 4, 1},
+	}
+	for _, test := range tests {
+		st.images = nil
+		_, err = term.Write([]byte("\033[Hx\033]1337;File=" + test.args + ":" + data + "\a"))
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		imgs := st.Images()
+		if len(imgs) != 1 {
+			t.Fatal(test.args, imgs)
+		}
+		if p := imgs[0]; p.X != 1 || p.Y != 0 || p.Cols != test.cols || p.Rows != test.rows {
+			t.Fatal(test.args, p)
+		}
+		if x, y := st.Cursor(); x != 0 || y != test.rows {
+			t.Fatal(test.args, x, y)
+		}
+	}
+
+	// a tall image scaled to the width is scaled down to fit on the
+	// screen, keeping its 1:4 aspect ratio
+	buf.Reset()
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 4, 16))); err != nil {
+		t.Fatal(err)
+	}
+	data = base64.StdEncoding.EncodeToString(buf.Bytes())
+	st.images = nil
+	_, err = term.Write([]byte("\033[H\033]1337;File=inline=1;width=4096:" + data + "\a"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	imgs := st.Images()
+	if len(imgs) != 1 {
+		t.Fatal(imgs)
+	}
+	if p := imgs[0]; p.Cols != 3 || p.Rows != 6 { // 6x24 pixels
+		t.Fatal(p)
+	}
+}
+
+func TestITermLargeImage(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.SetCellSize(2, 4)
+	term.Resize(10, 6)
+
+	// a file larger than other STR sequences may be
+	img := image.NewNRGBA(image.Rect(0, 0, 600, 600))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	if len(data) <= maxStrLen {
+		t.Fatal(len(data))
+	}
+	_, err = term.Write([]byte("\033]1337;File=inline=1;width=2;height=2:" + data + "\a"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if imgs := st.Images(); len(imgs) != 1 {
+		t.Fatal(imgs)
+	}
+}
+
+func TestITermUserVars(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = term.Write([]byte("\033]1337;SetUserVar=host=" + base64.StdEncoding.EncodeToString([]byte("a;b")) + "\a" +
+		"\033]1337;CurrentDir=/tmp/x y\a"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !st.Changed(ChangedUserVars) || st.UserVars()["host"] != "a;b" {
+		t.Fatal(st.UserVars())
+	}
+	if st.WorkingDirectory() != "file:///tmp/x%20y" {
+		t.Fatal(st.WorkingDirectory())
+	}
+}
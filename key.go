@@ -0,0 +1,318 @@
+package terminal
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Key is a key on the keyboard. Keys that produce text are their rune, as
+// given by the keyboard layout, and other keys are above unicode.MaxRune.
+type Key rune
+
+// Keys that are control characters.
+const (
+	KeyBackspace Key = 0x7f
+	KeyTab       Key = '\t'
+	KeyEnter     Key = '\r'
+	KeyEscape    Key = 0x1b
+)
+
+// Special keys.
+const (
+	KeyUp Key = unicode.MaxRune + 1 + iota
+	KeyDown
+	KeyRight
+	KeyLeft
+	KeyHome
+	KeyEnd
+	KeyInsert
+	KeyDelete
+	KeyPageUp
+	KeyPageDown
+	KeyF1
+	KeyF2
+	KeyF3
+	KeyF4
+	KeyF5
+	KeyF6
+	KeyF7
+	KeyF8
+	KeyF9
+	KeyF10
+	KeyF11
+	KeyF12
+	KeyF13
+	KeyF14
+	KeyF15
+	KeyF16
+	KeyF17
+	KeyF18
+	KeyF19
+	KeyF20
+	KeyF21
+	KeyF22
+	KeyF23
+	KeyF24
+	KeyKP0
+	KeyKP1
+	KeyKP2
+	KeyKP3
+	KeyKP4
+	KeyKP5
+	KeyKP6
+	KeyKP7
+	KeyKP8
+	KeyKP9
+	KeyKPDecimal
+	KeyKPDivide
+	KeyKPMultiply
+	KeyKPSubtract
+	KeyKPAdd
+	KeyKPEnter
+	KeyKPEqual
+)
+
+// Modifier is a set of modifier keys held during a key press.
+type Modifier uint8
+
+// Modifier keys, in the order of the xterm modifier parameter bits.
+const (
+	ModShift Modifier = 1 << iota
+	ModAlt
+	ModCtrl
+	ModMeta
+)
+
+// keyFinal is the final byte of CSI 1 ; mods X and SS3 X sequences.
+var keyFinal = map[Key]byte{
+	KeyUp:    'A',
+	KeyDown:  'B',
+	KeyRight: 'C',
+	KeyLeft:  'D',
+	KeyHome:  'H',
+	KeyEnd:   'F',
+	KeyF1:    'P',
+	KeyF2:    'Q',
+	KeyF3:    'R',
+	KeyF4:    'S',
+}
+
+// keyTilde is the number of CSI n ; mods ~ sequences.
+var keyTilde = map[Key]int{
+	KeyInsert:   2,
+	KeyDelete:   3,
+	KeyPageUp:   5,
+	KeyPageDown: 6,
+	KeyF5:       15,
+	KeyF6:       17,
+	KeyF7:       18,
+	KeyF8:       19,
+	KeyF9:       20,
+	KeyF10:      21,
+	KeyF11:      23,
+	KeyF12:      24,
+}
+
+// keypad is the final byte of SS3 X in application keypad mode, and the
+// character sent otherwise.
+var keypad = map[Key]struct {
+	app  byte
+	char byte
+}{
+	KeyKP0:        {'p', '0'},
+	KeyKP1:        {'q', '1'},
+	KeyKP2:        {'r', '2'},
+	KeyKP3:        {'s', '3'},
+	KeyKP4:        {'t', '4'},
+	KeyKP5:        {'u', '5'},
+	KeyKP6:        {'v', '6'},
+	KeyKP7:        {'w', '7'},
+	KeyKP8:        {'x', '8'},
+	KeyKP9:        {'y', '9'},
+	KeyKPDecimal:  {'n', '.'},
+	KeyKPDivide:   {'o', '/'},
+	KeyKPMultiply: {'j', '*'},
+	KeyKPSubtract: {'m', '-'},
+	KeyKPAdd:      {'k', '+'},
+	KeyKPEnter:    {'M', '\r'},
+	KeyKPEqual:    {'X', '='},
+}
+
+// KeyEventType is whether a key was pressed, repeated or released.
+type KeyEventType uint8
+
+// Key event types
+const (
+	KeyPress KeyEventType = iota
+	KeyRepeat
+	KeyRelease
+)
+
+// KeyEvent is a key event. Text is the text the key produces, if any,
+// which may differ from the key for composed input.
+type KeyEvent struct {
+	Key  Key
+	Mods Modifier
+	Type KeyEventType
+	Text string
+}
+
+// SendKey writes the sequence for a key press to the response writer,
+// encoded as xterm does for the current modes.
+func (t *VT) SendKey(key Key, mods Modifier) error {
+	return t.SendKeyEvent(KeyEvent{Key: key, Mods: mods})
+}
+
+// SendKeyEvent writes the sequence for a key event to the response writer.
+// Repeats are sent as presses and releases are not sent, unless the
+// application enabled them with the kitty keyboard protocol.
+func (t *VT) SendKeyEvent(e KeyEvent) error {
+	t.dest.lock()
+	b := t.dest.encodeKeyEvent(e)
+	w := t.dest.w
+	t.dest.unlock()
+	if len(b) == 0 || w == nil {
+		return nil
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// encodeKeyEvent returns the bytes sent for a key event, or nil if nothing
+// is sent.
+func (t *State) encodeKeyEvent(e KeyEvent) []byte {
+	if t.mode&ModeKeyboardLock != 0 {
+		return nil
+	}
+	if flags := t.keyboardFlags(); flags != 0 {
+		return t.encodeKittyKey(e, flags)
+	}
+	if e.Type == KeyRelease {
+		return nil
+	}
+	if e.Text != "" && e.Mods&^ModShift == 0 {
+		return []byte(e.Text)
+	}
+	if e.Key >= 0 && e.Key <= unicode.MaxRune && t.modifyOtherKey(rune(e.Key), e.Mods) {
+		return []byte("\033[27;" + strconv.Itoa(1+int(e.Mods)) + ";" + strconv.Itoa(int(e.Key)) + "~")
+	}
+	return t.encodeKey(e.Key, e.Mods)
+}
+
+// setKeyModifierOptions sets an xterm key modifier resource, or resets all
+// of them if res is negative. Only modifyOtherKeys (4) is supported.
+func (t *State) setKeyModifierOptions(res, value int) {
+	switch res {
+	case -1, 4:
+		t.modOtherKeys = value
+	default:
+		t.logf("unsupported key modifier resource %d\n", res)
+	}
+}
+
+// modifyOtherKey returns whether a key with modifiers is sent as
+// CSI 27 ; mods ; code ~ for the modifyOtherKeys level. Level 1 only does
+// so for combinations that have no usual encoding, and level 2 for all but
+// shifted text.
+func (t *State) modifyOtherKey(c rune, mods Modifier) bool {
+	switch {
+	case mods == 0:
+		return false
+	case t.modOtherKeys >= 2:
+		return mods&^ModShift != 0 || unicode.IsControl(c)
+	case t.modOtherKeys == 1:
+		if unicode.IsControl(c) {
+			return mods&(ModCtrl|ModShift) != 0 && !(c == rune(KeyTab) && mods == ModShift)
+		}
+		if mods&ModCtrl == 0 {
+			return false
+		}
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' {
+			return mods&ModShift != 0
+		}
+		return !strings.ContainsRune(" @[\\]^_?2345678", c)
+	}
+	return false
+}
+
+// encodeKey returns the bytes sent for a key press, or nil if nothing is
+// sent.
+func (t *State) encodeKey(key Key, mods Modifier) []byte {
+	if t.mode&ModeKeyboardLock != 0 {
+		return nil
+	}
+	// xterm sends F13-F24 as shifted F1-F12
+	if key >= KeyF13 && key <= KeyF24 {
+		key -= KeyF13 - KeyF1
+		mods |= ModShift
+	}
+	param := 1 + int(mods)
+
+	if final, ok := keyFinal[key]; ok {
+		if mods != 0 {
+			return []byte("\033[1;" + strconv.Itoa(param) + string(final))
+		}
+		if key >= KeyF1 || t.mode&ModeAppCursor != 0 {
+			return []byte{'\033', 'O', final}
+		}
+		return []byte{'\033', '[', final}
+	}
+	if n, ok := keyTilde[key]; ok {
+		s := "\033[" + strconv.Itoa(n)
+		if mods != 0 {
+			s += ";" + strconv.Itoa(param)
+		}
+		return []byte(s + "~")
+	}
+	if kp, ok := keypad[key]; ok {
+		// with num lock, digits are sent as digits in either mode
+		digit := key <= KeyKPDecimal && t.numlock
+		if t.mode&ModeAppKeypad != 0 && !digit && mods == 0 {
+			return []byte{'\033', 'O', kp.app}
+		}
+		key = Key(kp.char)
+		if key == KeyEnter && t.mode&ModeCRLF != 0 {
+			return []byte("\r\n")
+		}
+	}
+	if key > unicode.MaxRune || key < 0 {
+		return nil
+	}
+	return t.encodeRune(rune(key), mods)
+}
+
+// encodeRune returns the bytes sent for a key that produces text or a
+// control character.
+func (t *State) encodeRune(c rune, mods Modifier) []byte {
+	var b []byte
+	if mods&(ModAlt|ModMeta) != 0 {
+		b = append(b, '\033')
+	}
+	switch {
+	case c == rune(KeyEnter) && t.mode&ModeCRLF != 0:
+		return append(b, '\r', '\n')
+	case c == rune(KeyTab) && mods&ModShift != 0:
+		return append(b, "\033[Z"...)
+	case c == rune(KeyBackspace) && mods&ModCtrl != 0:
+		return append(b, '\b')
+	case mods&ModCtrl != 0:
+		switch {
+		case c == ' ' || c == '@' || c == '2':
+			c = 0
+		case c >= 'a' && c <= 'z':
+			c -= 'a' - 1
+		case c >= '[' && c <= '_':
+			c -= 0x40
+		case c >= '3' && c <= '7':
+			// xterm maps Ctrl+3 to Ctrl+7 to ESC, FS, GS, RS, US
+			c = 0x1b + c - '3'
+		case c == '8' || c == '?':
+			c = 0x7f
+		case c >= 'A' && c <= 'Z':
+			c -= 'A' - 1
+		}
+	}
+	return utf8.AppendRune(b, c)
+}
@@ -0,0 +1,103 @@
+package terminal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSendKey(t *testing.T) {
+	tests := []struct {
+		setup string
+		key   Key
+		mods  Modifier
+		want  string
+	}{
+		{"", 'a', 0, "a"},
+		{"", 'é', 0, "é"},
+		{"", 'c', ModCtrl, "\x03"},
+		{"", 'x', ModAlt, "\033x"},
+		{"", ' ', ModCtrl, "\x00"},
+		{"", KeyTab, ModShift, "\033[Z"},
+		{"", KeyEnter, 0, "\r"},
+		{"\033[20h", KeyEnter, 0, "\r\n"},
+		{"", KeyUp, 0, "\033[A"},
+		{"\033[?1h", KeyUp, 0, "\033OA"},
+		{"\033[?1h", KeyLeft, ModCtrl, "\033[1;5D"},
+		{"", KeyEnd, ModShift | ModAlt, "\033[1;4F"},
+		{"", KeyF1, 0, "\033OP"},
+		{"", KeyF4, ModCtrl, "\033[1;5S"},
+		{"", KeyF5, 0, "\033[15~"},
+		{"", KeyF12, ModAlt, "\033[24;3~"},
+		{"", KeyF13, 0, "\033[1;2P"},
+		{"", KeyF24, 0, "\033[24;2~"},
+		{"", KeyDelete, 0, "\033[3~"},
+		{"", KeyPageDown, ModCtrl, "\033[6;5~"},
+		{"", KeyKP5, 0, "5"},
+		{"\033=", KeyKP5, 0, "5"},
+		{"\033=", KeyKPAdd, 0, "\033Ok"},
+		{"\033=", KeyKPEnter, 0, "\033OM"},
+		{"", KeyKPEnter, 0, "\r"},
+		{"\033[2h", 'a', 0, ""},
+	}
+	for _, test := range tests {
+		var st State
+		term, err := Create(&st, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		term.SetResponseWriter(&buf)
+		_, err = term.Write([]byte(test.setup))
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if err := term.SendKey(test.key, test.mods); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("%q %x %d: got %q, want %q", test.setup, test.key, test.mods, buf.String(), test.want)
+		}
+	}
+}
+
+func TestModifyOtherKeys(t *testing.T) {
+	tests := []struct {
+		setup string
+		key   Key
+		mods  Modifier
+		want  string
+	}{
+		{"\033[>4;1m", 'a', ModCtrl, "\x01"},
+		{"\033[>4;1m", 'A', ModCtrl | ModShift, "\033[27;6;65~"},
+		{"\033[>4;1m", '1', ModCtrl, "\033[27;5;49~"},
+		{"\033[>4;1m", 'x', ModAlt, "\033x"},
+		{"\033[>4;1m", KeyEnter, ModCtrl, "\033[27;5;13~"},
+		{"\033[>4;1m", KeyTab, ModShift, "\033[Z"},
+		{"\033[>4;2m", 'a', ModCtrl, "\033[27;5;97~"},
+		{"\033[>4;2m", 'x', ModAlt, "\033[27;3;120~"},
+		{"\033[>4;2m", 'A', ModShift, "A"},
+		{"\033[>4;2m", KeyUp, ModCtrl, "\033[1;5A"},
+		{"\033[>4;2m\033[>4n", 'a', ModCtrl, "\x01"},
+		{"\033[>4;2m\033[>4m", 'a', ModCtrl, "\x01"},
+	}
+	for _, test := range tests {
+		var st State
+		term, err := Create(&st, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		term.SetResponseWriter(&buf)
+		_, err = term.Write([]byte(test.setup))
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if err := term.SendKey(test.key, test.mods); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("%q %x %d: got %q, want %q", test.setup, test.key, test.mods, buf.String(), test.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package terminal
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Kitty keyboard protocol flags.
+const (
+	keyDisambiguate = 1 << iota
+	keyEventTypes
+	keyAlternates
+	keyAllAsEscapes
+	keyText
+	keyFlagsMask = 1<<iota - 1
+)
+
+// maxKeyFlags bounds the keyboard flags stacks; the oldest entries are
+// dropped.
+const maxKeyFlags = 16
+
+// kittyKeys maps keys to their kitty keyboard protocol number and final
+// byte.
+var kittyKeys = map[Key]struct {
+	code  int
+	final byte
+}{
+	KeyEscape:    {27, 'u'},
+	KeyEnter:     {13, 'u'},
+	KeyTab:       {9, 'u'},
+	KeyBackspace: {127, 'u'},
+	KeyInsert:    {2, '~'},
+	KeyDelete:    {3, '~'},
+	KeyLeft:      {1, 'D'},
+	KeyRight:     {1, 'C'},
+	KeyUp:        {1, 'A'},
+	KeyDown:      {1, 'B'},
+	KeyPageUp:    {5, '~'},
+	KeyPageDown:  {6, '~'},
+	KeyHome:      {1, 'H'},
+	KeyEnd:       {1, 'F'},
+	KeyF1:        {1, 'P'},
+	KeyF2:        {1, 'Q'},
+	KeyF3:        {13, '~'},
+	KeyF4:        {1, 'S'},
+	KeyF5:        {15, '~'},
+	KeyF6:        {17, '~'},
+	KeyF7:        {18, '~'},
+	KeyF8:        {19, '~'},
+	KeyF9:        {20, '~'},
+	KeyF10:       {21, '~'},
+	KeyF11:       {23, '~'},
+	KeyF12:       {24, '~'},
+}
+
+// keyboardFlags returns the kitty keyboard flags of the current screen.
+func (t *State) keyboardFlags() int {
+	stack := t.keyFlagsStack()
+	if len(*stack) == 0 {
+		return 0
+	}
+	return (*stack)[len(*stack)-1]
+}
+
+func (t *State) keyFlagsStack() *[]int {
+	if t.mode&ModeAltScreen != 0 {
+		return &t.keyFlags[1]
+	}
+	return &t.keyFlags[0]
+}
+
+func (t *State) pushKeyboardFlags(flags int) {
+	stack := t.keyFlagsStack()
+	if len(*stack) >= maxKeyFlags {
+		*stack = append((*stack)[:0], (*stack)[1:]...)
+	}
+	*stack = append(*stack, flags&keyFlagsMask)
+}
+
+func (t *State) popKeyboardFlags(n int) {
+	stack := t.keyFlagsStack()
+	*stack = (*stack)[:len(*stack)-min(max(n, 0), len(*stack))]
+}
+
+// setKeyboardFlags replaces (mode 1), sets (mode 2) or resets (mode 3)
+// the current flags.
+func (t *State) setKeyboardFlags(flags, mode int) {
+	stack := t.keyFlagsStack()
+	if len(*stack) == 0 {
+		*stack = append(*stack, 0)
+	}
+	cur := &(*stack)[len(*stack)-1]
+	flags &= keyFlagsMask
+	switch mode {
+	case 1:
+		*cur = flags
+	case 2:
+		*cur |= flags
+	case 3:
+		*cur &^= flags
+	default:
+		t.logf("unknown keyboard flags mode %d\n", mode)
+	}
+}
+
+// encodeKittyKey returns the bytes sent for a key event under the kitty
+// keyboard protocol with flags.
+func (t *State) encodeKittyKey(e KeyEvent, flags int) []byte {
+	if e.Type == KeyRelease && flags&keyEventTypes == 0 {
+		return nil
+	}
+	all := flags&keyAllAsEscapes != 0
+	k, functional := kittyKeys[e.Key]
+	text := false
+	switch {
+	case functional:
+	case e.Key >= KeyF13 && e.Key <= KeyKPEqual:
+		// private use codes
+		if e.Key <= KeyF24 {
+			k.code = 57376 + int(e.Key-KeyF13)
+		} else {
+			k.code = 57399 + int(e.Key-KeyKP0)
+		}
+		k.final = 'u'
+		if !all && e.Mods == 0 && e.Key >= KeyKP0 {
+			if e.Type == KeyRelease {
+				return nil
+			}
+			return t.encodeKey(e.Key, 0)
+		}
+	case e.Key < 0 || e.Key > unicode.MaxRune:
+		return nil
+	default:
+		// keys that produce text are reported by their unshifted key
+		k.code, k.final = int(unicode.ToLower(rune(e.Key))), 'u'
+		text = true
+		if !all && e.Mods&^ModShift == 0 {
+			if e.Type == KeyRelease {
+				return nil
+			}
+			if e.Text != "" {
+				return []byte(e.Text)
+			}
+			return []byte(string(rune(e.Key)))
+		}
+	}
+	if !all && e.Mods == 0 && (e.Key == KeyEnter || e.Key == KeyTab || e.Key == KeyBackspace) {
+		// kept as is so that a shell can be used after an application
+		// exits without resetting the flags
+		if e.Type == KeyRelease {
+			return nil
+		}
+		return t.encodeKey(e.Key, 0)
+	}
+
+	var mods, codes string
+	if e.Mods != 0 {
+		mods = strconv.Itoa(1 + int(e.Mods))
+	}
+	if flags&keyEventTypes != 0 && e.Type != KeyPress {
+		if mods == "" {
+			mods = "1"
+		}
+		mods += ":" + strconv.Itoa(1+int(e.Type))
+	}
+	if all && flags&keyText != 0 && e.Type != KeyRelease && k.final == 'u' {
+		s := e.Text
+		if s == "" && text && e.Mods&^ModShift == 0 {
+			s = string(rune(e.Key))
+		}
+		var cs []string
+		for _, c := range s {
+			cs = append(cs, strconv.Itoa(int(c)))
+		}
+		codes = strings.Join(cs, ":")
+	}
+
+	b := []byte("\033[")
+	if k.code != 1 || k.final == 'u' || k.final == '~' || mods != "" {
+		b = strconv.AppendInt(b, int64(k.code), 10)
+	}
+	if mods != "" || codes != "" {
+		b = append(b, ';')
+		b = append(b, mods...)
+	}
+	if codes != "" {
+		b = append(b, ';')
+		b = append(b, codes...)
+	}
+	return append(b, k.final)
+}
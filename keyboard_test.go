@@ -0,0 +1,70 @@
+package terminal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestKeyboardFlags(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	term.SetResponseWriter(&buf)
+	_, err = term.Write([]byte("\033[?u\033[>1u\033[>3u\033[?u\033[=8;2u\033[?u\033[?1049h\033[?u\033[?1049l\033[<u\033[?u\033[<5u\033[?u"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if want := "\033[?0u\033[?3u\033[?11u\033[?0u\033[?1u\033[?0u"; buf.String() != want {
+		t.Fatalf("%q", buf.String())
+	}
+}
+
+func TestKittyKeyEncoding(t *testing.T) {
+	tests := []struct {
+		flags string
+		e     KeyEvent
+		want  string
+	}{
+		{"1", KeyEvent{Key: 'a'}, "a"},
+		{"1", KeyEvent{Key: 'A', Mods: ModShift}, "A"},
+		{"1", KeyEvent{Key: 'a', Type: KeyRelease}, ""},
+		{"1", KeyEvent{Key: 'c', Mods: ModCtrl}, "\033[99;5u"},
+		{"1", KeyEvent{Key: KeyEscape}, "\033[27u"},
+		{"1", KeyEvent{Key: KeyEnter}, "\r"},
+		{"1", KeyEvent{Key: KeyEnter, Mods: ModShift}, "\033[13;2u"},
+		{"1", KeyEvent{Key: KeyUp}, "\033[A"},
+		{"1", KeyEvent{Key: KeyF3, Mods: ModAlt}, "\033[13;3~"},
+		{"1", KeyEvent{Key: KeyF13}, "\033[57376u"},
+		{"3", KeyEvent{Key: KeyUp, Type: KeyRelease}, "\033[1;1:3A"},
+		{"3", KeyEvent{Key: 'x', Mods: ModCtrl, Type: KeyRepeat}, "\033[120;5:2u"},
+		{"8", KeyEvent{Key: 'a'}, "\033[97u"},
+		{"8", KeyEvent{Key: KeyEnter}, "\033[13u"},
+		{"8", KeyEvent{Key: KeyKP1}, "\033[57400u"},
+		{"24", KeyEvent{Key: 'A', Mods: ModShift}, "\033[97;2;65u"},
+		{"24", KeyEvent{Key: 'e', Text: "é"}, "\033[101;;233u"},
+		{"26", KeyEvent{Key: 'a', Type: KeyRelease}, "\033[97;1:3u"},
+	}
+	for _, test := range tests {
+		var st State
+		term, err := Create(&st, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		term.SetResponseWriter(&buf)
+		_, err = term.Write([]byte("\033[>" + test.flags + "u"))
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if err := term.SendKeyEvent(test.e); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("%s %+v: got %q, want %q", test.flags, test.e, buf.String(), test.want)
+		}
+	}
+}
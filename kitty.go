@@ -0,0 +1,392 @@
+package terminal
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxKittyData bounds the decoded size of a kitty graphics image.
+const maxKittyData = 4 * maxSixelSize * maxSixelSize
+
+// maxKittyBytes bounds the pixel data of stored kitty graphics images,
+// beyond which the oldest are evicted.
+const maxKittyBytes = 320 << 20
+
+// kittyCommand is the control data of a kitty graphics protocol command.
+type kittyCommand struct {
+	keys map[byte]string
+}
+
+func parseKittyCommand(s string) kittyCommand {
+	cmd := kittyCommand{make(map[byte]string)}
+	for _, kv := range strings.Split(s, ",") {
+		if len(kv) < 2 || kv[1] != '=' {
+			continue
+		}
+		cmd.keys[kv[0]] = kv[2:]
+	}
+	return cmd
+}
+
+func (c kittyCommand) str(key byte, def string) string {
+	if v, ok := c.keys[key]; ok {
+		return v
+	}
+	return def
+}
+
+func (c kittyCommand) num(key byte, def int) int {
+	v, err := strconv.Atoi(c.keys[key])
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// kittyTransfer is an image transmission in progress, made of chunks.
+type kittyTransfer struct {
+	cmd  kittyCommand
+	data []byte
+}
+
+// kittyImage is a transmitted image, kept until deleted or evicted.
+type kittyImage struct {
+	img  image.Image
+	size int // bytes of pixel data
+	seq  int // order of transmission
+}
+
+// kittyPlacement is a placement of a transmitted image on the screen.
+type kittyPlacement struct {
+	image, placement int    // kitty ids
+	id               uint32 // internal id of the cells
+}
+
+type kittyError struct {
+	code, msg string
+}
+
+func (e *kittyError) Error() string {
+	return e.code + ":" + e.msg
+}
+
+// handleKittyGraphics handles an APC G command of the kitty graphics
+// protocol, with the control data and payload separated by ';'.
+func (t *State) handleKittyGraphics(s string) {
+	ctrl, payload := s, ""
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		ctrl, payload = s[:i], s[i+1:]
+	}
+	cmd := parseKittyCommand(ctrl)
+	if t.str.overflow {
+		t.kittyRespond(cmd, &kittyError{"EINVAL", "payload too large"})
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		// chunks may omit padding
+		data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+	}
+	if err != nil {
+		t.kittyTransfer = nil
+		t.kittyRespond(cmd, &kittyError{"EINVAL", "bad base64 payload"})
+		return
+	}
+
+	// continuation chunks carry only m and q; the first chunk's keys apply
+	if tr := t.kittyTransfer; tr != nil {
+		if len(tr.data)+len(data) > maxKittyData {
+			t.kittyTransfer = nil
+			t.kittyRespond(tr.cmd, &kittyError{"EFBIG", "image too large"})
+			return
+		}
+		tr.data = append(tr.data, data...)
+		if cmd.num('m', 0) == 1 {
+			return
+		}
+		t.kittyTransfer = nil
+		cmd, data = tr.cmd, tr.data
+	} else if cmd.num('m', 0) == 1 {
+		t.kittyTransfer = &kittyTransfer{cmd, data}
+		return
+	}
+
+	switch a := cmd.str('a', "t"); a {
+	case "t", "T", "q":
+		err = t.kittyTransmit(&cmd, data, a)
+	case "p":
+		err = t.kittyPut(cmd)
+	case "d":
+		t.kittyDelete(cmd)
+		return
+	default:
+		err = &kittyError{"EINVAL", "unknown action " + a}
+	}
+	t.kittyRespond(cmd, err)
+}
+
+// kittyRespond answers a command if the application gave an image id or
+// number and did not ask for quiet.
+func (t *State) kittyRespond(cmd kittyCommand, err error) {
+	id, num := cmd.num('i', 0), cmd.num('I', 0)
+	if id == 0 && num == 0 {
+		return
+	}
+	q := cmd.num('q', 0)
+	if err == nil && q >= 1 || err != nil && q >= 2 {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("\033_G")
+	fmt.Fprintf(&b, "i=%d", id)
+	if num != 0 {
+		fmt.Fprintf(&b, ",I=%d", num)
+	}
+	if p := cmd.num('p', 0); p != 0 {
+		fmt.Fprintf(&b, ",p=%d", p)
+	}
+	if err != nil {
+		b.WriteString(";" + err.Error())
+	} else {
+		b.WriteString(";OK")
+	}
+	b.WriteString("\033\\")
+	t.respond("%s", b.String())
+}
+
+func (t *State) kittyTransmit(cmd *kittyCommand, data []byte, action string) error {
+	if m := cmd.str('t', "d"); m != "d" {
+		// reading files or shared memory on behalf of the application is
+		// not supported
+		return &kittyError{"EINVAL", "unsupported transmission medium " + m}
+	}
+	img, err := decodeKittyImage(*cmd, data)
+	if err != nil {
+		return err
+	}
+	if action == "q" {
+		return nil
+	}
+	id := cmd.num('i', 0)
+	if id == 0 {
+		// allocate an id for an image number, or an anonymous image
+		for id = t.kittyNextID + 1; t.kittyImages[id] != nil; id++ {
+		}
+		t.kittyNextID = id
+		if cmd.num('I', 0) != 0 {
+			cmd.keys['i'] = strconv.Itoa(id)
+		}
+	}
+	t.kittyStore(id, img)
+	if action == "T" {
+		return t.kittyPlace(*cmd, id, img)
+	}
+	return nil
+}
+
+func decodeKittyImage(cmd kittyCommand, data []byte) (image.Image, error) {
+	if cmd.str('o', "") == "z" {
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, &kittyError{"EINVAL", "bad zlib data"}
+		}
+		data, err = io.ReadAll(io.LimitReader(zr, maxKittyData+1))
+		if err != nil {
+			return nil, &kittyError{"EINVAL", "bad zlib data"}
+		}
+		if len(data) > maxKittyData {
+			return nil, &kittyError{"EFBIG", "image too large"}
+		}
+	}
+	f := cmd.num('f', 32)
+	if f == 100 {
+		// check the size before decoding allocates the pixels
+		cfg, err := png.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, &kittyError{"EBADPNG", err.Error()}
+		}
+		if cfg.Width > maxSixelSize || cfg.Height > maxSixelSize {
+			return nil, &kittyError{"EFBIG", "image too large"}
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, &kittyError{"EBADPNG", err.Error()}
+		}
+		return img, nil
+	}
+	if f != 24 && f != 32 {
+		return nil, &kittyError{"EINVAL", "unknown format " + strconv.Itoa(f)}
+	}
+	w, h := cmd.num('s', 0), cmd.num('v', 0)
+	if w <= 0 || h <= 0 || w > maxSixelSize || h > maxSixelSize {
+		return nil, &kittyError{"EINVAL", "bad image size"}
+	}
+	bpp := f / 8
+	if len(data) < w*h*bpp {
+		return nil, &kittyError{"ENODATA", "insufficient image data"}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i, j := 0, 0; i < w*h*bpp; i, j = i+bpp, j+4 {
+		img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = data[i], data[i+1], data[i+2], 0xff
+		if bpp == 4 {
+			img.Pix[j+3] = data[i+3]
+		}
+	}
+	return img, nil
+}
+
+func (t *State) kittyPut(cmd kittyCommand) error {
+	id := cmd.num('i', 0)
+	ki := t.kittyImages[id]
+	if ki == nil {
+		return &kittyError{"ENOENT", "no such image " + strconv.Itoa(id)}
+	}
+	return t.kittyPlace(cmd, id, ki.img)
+}
+
+// kittyStore stores img as image id, evicting the oldest images if the
+// pixel data of all images goes over maxKittyBytes. Placements of evicted
+// images stay on the screen.
+func (t *State) kittyStore(id int, img image.Image) {
+	if t.kittyImages == nil {
+		t.kittyImages = make(map[int]*kittyImage)
+	}
+	t.kittyFree(id)
+	b := img.Bounds()
+	size := 4 * b.Dx() * b.Dy()
+	for t.kittyBytes+size > maxKittyBytes && len(t.kittyImages) > 0 {
+		oldest := -1
+		for k, ki := range t.kittyImages {
+			if oldest < 0 || ki.seq < t.kittyImages[oldest].seq {
+				oldest = k
+			}
+		}
+		t.kittyFree(oldest)
+	}
+	t.kittySeq++
+	t.kittyImages[id] = &kittyImage{img, size, t.kittySeq}
+	t.kittyBytes += size
+}
+
+// kittyFree drops the data of image id.
+func (t *State) kittyFree(id int) {
+	if ki := t.kittyImages[id]; ki != nil {
+		t.kittyBytes -= ki.size
+		delete(t.kittyImages, id)
+	}
+}
+
+// kittyPlace displays an image at the cursor. The displayed part is given
+// by the source rectangle x, y, w, h, and is scaled to c columns and r rows,
+// then scaled down as needed to fit on the screen.
+func (t *State) kittyPlace(cmd kittyCommand, id int, img image.Image) error {
+	b := img.Bounds()
+	src := image.Rectangle{Min: image.Pt(cmd.num('x', 0), cmd.num('y', 0))}
+	src.Max.X = src.Min.X + cmd.num('w', b.Dx()-src.Min.X)
+	src.Max.Y = src.Min.Y + cmd.num('h', b.Dy()-src.Min.Y)
+	src = src.Add(b.Min).Intersect(b)
+	if src.Empty() {
+		return &kittyError{"EINVAL", "empty source rectangle"}
+	}
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok && src != b {
+		img = sub.SubImage(src)
+	}
+
+	cols, rows := min(cmd.num('c', 0), maxSixelSize), min(cmd.num('r', 0), maxSixelSize)
+	switch {
+	case cols <= 0 && rows <= 0:
+		cols = (src.Dx() + t.cellW - 1) / t.cellW
+		rows = (src.Dy() + t.cellH - 1) / t.cellH
+	case rows <= 0:
+		// keep the aspect ratio
+		rows = max((cols*t.cellW*src.Dy()/src.Dx()+t.cellH-1)/t.cellH, 1)
+	case cols <= 0:
+		cols = max((rows*t.cellH*src.Dx()/src.Dy()+t.cellW-1)/t.cellW, 1)
+	}
+	cols, rows = t.fitScreen(cols, rows)
+
+	// replace an existing placement with the same ids
+	p := cmd.num('p', 0)
+	if p != 0 {
+		t.kittyRemove(func(kp kittyPlacement) bool {
+			return kp.image == id && kp.placement == p
+		}, false)
+	}
+	cur := t.cur
+	t.placeImage(img, cols, rows)
+	t.kittyPlacements = append(t.kittyPlacements, kittyPlacement{id, p, t.nextImage})
+	if cmd.num('C', 0) == 1 {
+		t.cur = cur
+	} else {
+		// the cursor ends up after the last column of the image
+		t.moveTo(cur.x+cols, t.cur.y)
+	}
+	return nil
+}
+
+// kittyDelete handles the delete action, where lowercase specifiers delete
+// placements and uppercase ones also free the image data.
+func (t *State) kittyDelete(cmd kittyCommand) {
+	d := cmd.str('d', "a")
+	free := d != strings.ToLower(d)
+	id, p := cmd.num('i', 0), cmd.num('p', 0)
+	switch strings.ToLower(d) {
+	case "a": // all
+		t.kittyRemove(func(kittyPlacement) bool { return true }, free)
+	case "i": // by image id and optionally placement id
+		t.kittyRemove(func(kp kittyPlacement) bool {
+			return kp.image == id && (p == 0 || kp.placement == p)
+		}, free)
+		if free && p == 0 {
+			t.kittyFree(id)
+		}
+	case "c": // intersecting the cursor
+		x, y := t.cur.x, t.cur.y
+		t.kittyRemove(func(kp kittyPlacement) bool {
+			return t.lines[y].ext(x).img == kp.id
+		}, free)
+	default:
+		t.logf("unsupported kitty delete '%s'\n", d)
+	}
+}
+
+// kittyRemove removes the placements matching fn from the screen, freeing
+// the images left without placements if free is true.
+func (t *State) kittyRemove(fn func(kp kittyPlacement) bool, free bool) {
+	keep := t.kittyPlacements[:0]
+	for _, kp := range t.kittyPlacements {
+		if !fn(kp) {
+			keep = append(keep, kp)
+			continue
+		}
+		t.removeImage(kp.id)
+		if free {
+			t.kittyFree(kp.image)
+		}
+	}
+	t.kittyPlacements = keep
+}
+
+// removeImage clears an image from the cells of the screen.
+func (t *State) removeImage(id uint32) {
+	for y := range t.lines {
+		l := &t.lines[y]
+		for x, e := range l.extras {
+			if e.img == id {
+				e.img, e.imgX, e.imgY = 0, 0, 0
+				l.setExt(x, e)
+				t.dirty[y] = true
+				t.changed |= ChangedScreen
+			}
+		}
+	}
+}
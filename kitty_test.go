@@ -0,0 +1,170 @@
+package terminal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestKittyGraphics(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp bytes.Buffer
+	term.SetResponseWriter(&resp)
+	term.SetCellSize(2, 4)
+	term.Resize(10, 4)
+
+	// a 4x2 RGB image sent in two chunks, then displayed at 3x1 cells
+	pix := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{255, 0, 0}, 8))
+	_, err = term.Write([]byte("x\033_Ga=t,f=24,s=4,v=2,i=7,m=1;" + pix[:16] + "\033\\" +
+		"\033_Gm=0;" + pix[16:] + "\033\\" +
+		"\033_Ga=p,i=7,c=3,q=1\033\\"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if s := resp.String(); s != "\033_Gi=7;OK\033\\" {
+		t.Fatalf("%q", s)
+	}
+	imgs := st.Images()
+	if len(imgs) != 1 {
+		t.Fatal(imgs)
+	}
+	if p := imgs[0]; p.X != 1 || p.Y != 0 || p.Cols != 3 || p.Rows != 1 {
+		t.Fatal(p)
+	}
+	if x, y := st.Cursor(); x != 4 || y != 0 {
+		t.Fatal(x, y)
+	}
+
+	// errors are reported, and deleting by id clears the cells
+	resp.Reset()
+	_, err = term.Write([]byte("\033_Ga=p,i=8\033\\\033_Ga=d,d=i,i=7\033\\"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if s := resp.String(); s != "\033_Gi=8;ENOENT:no such image 8\033\\" {
+		t.Fatalf("%q", s)
+	}
+	if imgs := st.Images(); len(imgs) != 0 {
+		t.Fatal(imgs)
+	}
+
+	// the image data is kept until deleted with an uppercase specifier
+	resp.Reset()
+	_, err = term.Write([]byte("\033_Ga=p,i=7,C=1\033\\\033_Ga=d,d=I,i=7\033\\\033_Ga=p,i=7\033\\"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if s := resp.String(); s != "\033_Gi=7;OK\033\\\033_Gi=7;ENOENT:no such image 7\033\\" {
+		t.Fatalf("%q", s)
+	}
+	if x, y := st.Cursor(); x != 4 || y != 0 {
+		t.Fatal(x, y)
+	}
+}
+
+func TestKittyGraphicsLimits(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp bytes.Buffer
+	term.SetResponseWriter(&resp)
+	term.SetCellSize(2, 4)
+	term.Resize(10, 4)
+
+	// a PNG over the size limit is rejected before it is decoded
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, maxSixelSize+1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	_, err = term.Write([]byte("\033_Ga=t,f=100,i=1;" + data + "\033\\"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if s := resp.String(); s != "\033_Gi=1;EFBIG:image too large\033\\" {
+		t.Fatalf("%q", s)
+	}
+	// images larger than the screen are scaled down to fit, keeping the
+	// aspect ratio
+	pix := base64.StdEncoding.EncodeToString([]byte{255, 0, 0})
+	tests := []struct {
+		size       string
+		cols, rows int
+	}{
+		{"c=100000,r=100000", 4, 4},
+		{"c=100000", 8, 4},
+		{"r=100000", 8, 4},
+		{"c=20,r=2", 10, 1},
+	}
+	for _, test := range tests {
+		resp.Reset()
+		st.images = nil
+		_, err = term.Write([]byte("\033[H\033_Ga=T,f=24,s=1,v=1,i=2,q=1," + test.size + ";" + pix + "\033\\"))
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if s := resp.String(); s != "" {
+			t.Fatalf("%s: %q", test.size, s)
+		}
+		imgs := st.Images()
+		if len(imgs) != 1 {
+			t.Fatal(test.size, imgs)
+		}
+		if p := imgs[0]; p.Cols != test.cols || p.Rows != test.rows {
+			t.Fatal(test.size, p)
+		}
+	}
+	// the oldest images are evicted beyond the quota
+	size := maxSixelSize * maxSixelSize * 4
+	for id := 1; id <= maxKittyBytes/size+1; id++ {
+		st.kittyStore(id, &image.NRGBA{Rect: image.Rect(0, 0, maxSixelSize, maxSixelSize)})
+	}
+	if st.kittyImages[1] != nil || st.kittyImages[2] == nil || st.kittyBytes > maxKittyBytes {
+		t.Fatal(len(st.kittyImages), st.kittyBytes)
+	}
+}
+
+func TestKittyGraphicsSource(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.SetCellSize(2, 4)
+	term.Resize(10, 4)
+
+	// the part of a 4x4 image from the source offset
+	pix := base64.StdEncoding.EncodeToString(make([]byte, 4*4*3))
+	tests := []struct {
+		src  string
+		want image.Rectangle
+	}{
+		{"", image.Rect(0, 0, 4, 4)},
+		{"x=2,y=2", image.Rect(2, 2, 4, 4)},
+		{"x=1,y=2,w=2", image.Rect(1, 2, 3, 4)},
+		{"y=1,h=2", image.Rect(0, 1, 4, 3)},
+	}
+	for _, test := range tests {
+		st.images = nil
+		_, err = term.Write([]byte("\033[H\033_Ga=T,f=24,s=4,v=4,q=2," + test.src + ";" + pix + "\033\\"))
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		imgs := st.Images()
+		if len(imgs) != 1 {
+			t.Fatal(test.src, imgs)
+		}
+		if b := imgs[0].Image.Bounds(); b != test.want {
+			t.Fatal(test.src, b)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package terminal
+
+import "strings"
+
+// Hyperlink is a link set on cells by OSC 8. Cells sharing an ID belong to
+// the same link, even if they are not adjacent.
+type Hyperlink struct {
+	ID  string
+	URI string
+}
+
+// LinkSpan is a run of cells on a line with the same hyperlink.
+type LinkSpan struct {
+	Hyperlink
+	X0, X1, Y int
+}
+
+// minLinkGC is the number of interned hyperlinks before unused ones are
+// collected.
+const minLinkGC = 1024
+
+// CellLink returns the hyperlink at position (x, y), if any.
+func (t *State) CellLink(x, y int) (Hyperlink, bool) {
+	return t.link(t.lines[y].ext(x).link)
+}
+
+// HistoryCellLink is like CellLink, but for the scrollback history.
+func (t *State) HistoryCellLink(x, y int) (Hyperlink, bool) {
+	return t.link(t.history[y].ext(x).link)
+}
+
+// Links returns the spans of hyperlinked cells on the screen, from top
+// left to bottom right.
+func (t *State) Links() []LinkSpan {
+	var spans []LinkSpan
+	for y := range t.lines {
+		l := &t.lines[y]
+		if len(l.extras) == 0 {
+			continue
+		}
+		for x := 0; x < len(l.cells); x++ {
+			id := l.ext(x).link
+			if id == 0 {
+				continue
+			}
+			x0 := x
+			for x+1 < len(l.cells) && l.ext(x+1).link == id {
+				x++
+			}
+			link, _ := t.link(id)
+			spans = append(spans, LinkSpan{link, x0, x, y})
+		}
+	}
+	return spans
+}
+
+func (t *State) link(id uint32) (Hyperlink, bool) {
+	if id == 0 || int(id) >= len(t.links) {
+		return Hyperlink{}, false
+	}
+	return t.links[id], true
+}
+
+// handleHyperlink handles OSC 8 with its colon separated key=value params
+// and URI. An empty URI ends the link.
+func (t *State) handleHyperlink(params, uri string) {
+	if uri == "" {
+		t.cur.link = 0
+		return
+	}
+	var link Hyperlink
+	link.URI = uri
+	for _, p := range strings.Split(params, ":") {
+		if strings.HasPrefix(p, "id=") {
+			link.ID = p[3:]
+		}
+	}
+	t.cur.link = t.internLink(link)
+}
+
+func (t *State) internLink(link Hyperlink) uint32 {
+	if id, ok := t.linkIDs[link]; ok {
+		return id
+	}
+	if len(t.links) == 0 {
+		// id 0 is no link
+		t.links = []Hyperlink{{}}
+		t.linkIDs = make(map[Hyperlink]uint32)
+	} else if len(t.links) >= max(t.linkGC, minLinkGC) {
+		t.collectLinks()
+	}
+	id := uint32(len(t.links))
+	t.links = append(t.links, link)
+	t.linkIDs[link] = id
+	return id
+}
+
+// eachExt calls fn for the extra data of every cell of both screens and
+// the scrollback history that has any.
+func (t *State) eachExt(fn func(e *cellExt)) {
+	for _, lines := range [][]line{t.lines, t.altLines, t.history} {
+		for _, l := range lines {
+			for x, e := range l.extras {
+				fn(&e)
+				l.extras[x] = e
+			}
+		}
+	}
+}
+
+// eachLink calls fn for the hyperlink of every cell of both screens and the
+// scrollback history, and of the cursors.
+func (t *State) eachLink(fn func(id *uint32)) {
+	t.eachExt(func(e *cellExt) {
+		fn(&e.link)
+	})
+	fn(&t.cur.link)
+	fn(&t.curSaved.link)
+}
+
+// collectLinks drops hyperlinks no longer referenced by any cell,
+// renumbering the rest.
+func (t *State) collectLinks() {
+	remap := make([]uint32, len(t.links))
+	t.eachLink(func(id *uint32) {
+		remap[*id] = 1
+	})
+	links := []Hyperlink{{}}
+	t.linkIDs = make(map[Hyperlink]uint32)
+	for id := 1; id < len(remap); id++ {
+		if remap[id] != 0 {
+			remap[id] = uint32(len(links))
+			t.linkIDs[t.links[id]] = remap[id]
+			links = append(links, t.links[id])
+		}
+	}
+	t.links = links
+	t.eachLink(func(id *uint32) {
+		*id = remap[*id]
+	})
+	t.linkGC = 2 * len(links)
+}
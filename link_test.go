@@ -0,0 +1,87 @@
+package terminal
+
+import (
+	"io"
+	"strconv"
+	"testing"
+)
+
+func TestHyperlinks(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetHistoryLimit(10)
+	term.Resize(10, 2)
+	_, err = term.Write([]byte("a\033]8;id=x;http://a/?b;c\033\\bc\033]8;;\033\\d\r\n\033]8;;file:///e\aef\033]8;;\ag"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	links := st.Links()
+	expected := []LinkSpan{
+		{Hyperlink{"x", "http://a/?b;c"}, 1, 2, 0},
+		{Hyperlink{"", "file:///e"}, 0, 1, 1},
+	}
+	if len(links) != len(expected) {
+		t.Fatal(links)
+	}
+	for i := range links {
+		if links[i] != expected[i] {
+			t.Fatal(links[i])
+		}
+	}
+	if _, ok := st.CellLink(3, 0); ok {
+		t.Fatal("link after end")
+	}
+
+	// links survive scrolling into history
+	_, err = term.Write([]byte("\r\n"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if link, ok := st.HistoryCellLink(2, 0); !ok || link.ID != "x" {
+		t.Fatal(link)
+	}
+
+	// unused links are collected
+	for i := 0; i < 2*minLinkGC; i++ {
+		st.internLink(Hyperlink{URI: "file:///" + strconv.Itoa(i)})
+	}
+	if len(st.links) > minLinkGC+1 {
+		t.Fatal(len(st.links))
+	}
+	if link, ok := st.HistoryCellLink(1, 0); !ok || link.URI != "http://a/?b;c" {
+		t.Fatal(link)
+	}
+}
+
+func TestHyperlinksMove(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.Resize(10, 3)
+	// the link is moved by ICH and DCH, then copied by DECCRA
+	_, err = term.Write([]byte("a\033]8;;http://a\ab\033]8;;\ac\r\033[2@\033[3G\033[P\033[1;1;1;10;1;3;1$v"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	links := st.Links()
+	expected := []LinkSpan{
+		{Hyperlink{"", "http://a"}, 2, 2, 0},
+		{Hyperlink{"", "http://a"}, 2, 2, 2},
+	}
+	if len(links) != len(expected) {
+		t.Fatal(links)
+	}
+	for i := range links {
+		if links[i] != expected[i] {
+			t.Fatal(links[i])
+		}
+	}
+	if s := st.CellString(2, 2); s != "b" {
+		t.Fatal(s)
+	}
+}
@@ -0,0 +1,135 @@
+package terminal
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// MouseButton is a mouse button or wheel direction.
+type MouseButton uint8
+
+// Mouse buttons. MouseNone is for motion with no button held.
+const (
+	MouseLeft MouseButton = iota
+	MouseMiddle
+	MouseRight
+	MouseNone
+	MouseWheelUp
+	MouseWheelDown
+	MouseWheelLeft
+	MouseWheelRight
+	MouseButton8
+	MouseButton9
+	MouseButton10
+	MouseButton11
+)
+
+// MouseAction is what happened to a mouse button.
+type MouseAction uint8
+
+// Mouse actions
+const (
+	MousePress MouseAction = iota
+	MouseRelease
+	MouseMotion
+)
+
+// SendMouse reports a mouse event at pixel position (x, y) of the screen
+// to the application, if the mouse mode asks for it. Cells are found with
+// the cell size set by SetCellSize. For motion, button is the button held
+// or MouseNone.
+func (t *VT) SendMouse(button MouseButton, action MouseAction, x, y int, mods Modifier) error {
+	t.dest.lock()
+	b := t.dest.encodeMouse(button, action, x, y, mods)
+	w := t.dest.w
+	t.dest.unlock()
+	if len(b) == 0 || w == nil {
+		return nil
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// encodeMouse returns the report of a mouse event, or nil if the event is
+// not reported.
+func (t *State) encodeMouse(button MouseButton, action MouseAction, x, y int, mods Modifier) []byte {
+	mode := t.mode & ModeMouseMask
+	if mode == 0 {
+		return nil
+	}
+	wheel := button >= MouseWheelUp && button <= MouseWheelRight
+	switch {
+	case mode == ModeMouseX10 && action != MousePress:
+		return nil
+	case action == MouseRelease && wheel:
+		return nil
+	case action == MouseMotion && mode != ModeMouseMany &&
+		(mode != ModeMouseMotion || button == MouseNone):
+		return nil
+	}
+
+	x, y = max(x, 0), max(y, 0)
+	col := min(x/t.cellW, t.cols-1)
+	row := min(y/t.cellH, t.rows-1)
+	if action == MouseMotion && col == t.mouseCol && row == t.mouseRow &&
+		t.mode&ModeMouseSgrPixels == 0 {
+		// motion within a cell is not reported
+		return nil
+	}
+	t.mouseCol, t.mouseRow = col, row
+
+	var code int
+	switch {
+	case button < MouseNone:
+		code = int(button)
+	case button == MouseNone:
+		code = 3
+	case wheel:
+		code = 64 + int(button-MouseWheelUp)
+	default:
+		code = 128 + int(button-MouseButton8)
+	}
+	sgr := t.mode&(ModeMouseSgr|ModeMouseSgrPixels) != 0
+	if action == MouseRelease && !sgr {
+		// the button released is not known
+		code = 3
+	}
+	if action == MouseMotion {
+		code += 32
+	}
+	if mode != ModeMouseX10 {
+		if mods&ModShift != 0 {
+			code += 4
+		}
+		if mods&(ModAlt|ModMeta) != 0 {
+			code += 8
+		}
+		if mods&ModCtrl != 0 {
+			code += 16
+		}
+	}
+
+	final := 'M'
+	if action == MouseRelease {
+		final = 'm'
+	}
+	switch {
+	case t.mode&ModeMouseSgrPixels != 0:
+		return fmt.Appendf(nil, "\033[<%d;%d;%d%c", code, x+1, y+1, final)
+	case t.mode&ModeMouseSgr != 0:
+		return fmt.Appendf(nil, "\033[<%d;%d;%d%c", code, col+1, row+1, final)
+	case t.mode&ModeMouseUrxvt != 0:
+		return fmt.Appendf(nil, "\033[%d;%d;%dM", 32+code, col+1, row+1)
+	case t.mode&ModeMouseUtf8 != 0:
+		b := []byte("\033[M")
+		for _, v := range []int{code, col + 1, row + 1} {
+			b = utf8.AppendRune(b, rune(32+v))
+		}
+		return b
+	}
+	if 32+col+1 > 0xff || 32+row+1 > 0xff {
+		// out of range of the default encoding
+		return nil
+	}
+	return []byte{'\033', '[', 'M', byte(32 + code), byte(32 + col + 1), byte(32 + row + 1)}
+}
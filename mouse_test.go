@@ -0,0 +1,67 @@
+package terminal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSendMouse(t *testing.T) {
+	type event struct {
+		button MouseButton
+		action MouseAction
+		x, y   int
+		mods   Modifier
+	}
+	tests := []struct {
+		setup  string
+		events []event
+		want   string
+	}{
+		{"", []event{{MouseLeft, MousePress, 0, 0, 0}}, ""},
+		{"\033[?9h", []event{{MouseLeft, MousePress, 20, 40, ModCtrl}, {MouseLeft, MouseRelease, 20, 40, 0}}, "\033[M ##"},
+		{"\033[?1000h", []event{
+			{MouseRight, MousePress, 0, 0, ModShift},
+			{MouseRight, MouseMotion, 30, 0, 0},
+			{MouseRight, MouseRelease, 30, 0, 0},
+			{MouseWheelUp, MousePress, 0, 0, 0},
+			{MouseWheelUp, MouseRelease, 0, 0, 0},
+		}, "\033[M&!!\033[M#$!\033[M`!!"},
+		{"\033[?1002h", []event{
+			{MouseNone, MouseMotion, 20, 0, 0},
+			{MouseLeft, MouseMotion, 20, 0, 0},
+			{MouseLeft, MouseMotion, 25, 0, 0},
+		}, "\033[M@#!"},
+		{"\033[?1003h\033[?1006h", []event{
+			{MouseNone, MouseMotion, 0, 0, 0},
+			{MouseMiddle, MousePress, 0, 16, ModAlt},
+			{MouseMiddle, MouseRelease, 0, 16, 0},
+		}, "\033[<35;1;1M\033[<9;1;2M\033[<1;1;2m"},
+		{"\033[?1000h\033[?1016h", []event{{MouseButton8, MousePress, 21, 35, 0}}, "\033[<128;22;36M"},
+		{"\033[?1000h\033[?1015h", []event{{MouseLeft, MousePress, 160, 16, 0}}, "\033[32;17;2M"},
+		{"\033[?1000h\033[?1005h", []event{{MouseLeft, MousePress, 10 * 150, 0, 0}}, "\033[M \u00b7!"},
+	}
+	for _, test := range tests {
+		var st State
+		term, err := Create(&st, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		term.SetCellSize(10, 16)
+		term.Resize(200, 24)
+		var buf bytes.Buffer
+		term.SetResponseWriter(&buf)
+		_, err = term.Write([]byte(test.setup))
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		for _, e := range test.events {
+			if err := term.SendMouse(e.button, e.action, e.x, e.y, e.mods); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if buf.String() != test.want {
+			t.Errorf("%q: got %q, want %q", test.setup, buf.String(), test.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package terminal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// xterm's default ANSI colors
+var ansiPalette = [16]Color{
+	0x000000, 0xcd0000, 0x00cd00, 0xcdcd00,
+	0x0000ee, 0xcd00cd, 0x00cdcd, 0xe5e5e5,
+	0x7f7f7f, 0xff0000, 0x00ff00, 0xffff00,
+	0x5c5cff, 0xff00ff, 0x00ffff, 0xffffff,
+}
+
+func (t *State) resetPalette() {
+	for i := range t.palette {
+		t.palette[i] = defaultPaletteColor(i)
+	}
+	t.defaultFG = t.palette[LightGrey]
+	t.defaultBG = t.palette[Black]
+	t.cursorColor = t.defaultFG
+	t.changed |= ChangedPalette
+}
+
+func defaultPaletteColor(i int) Color {
+	switch {
+	case i < 16:
+		return colorRGB | ansiPalette[i]
+	case i < 232:
+		// 6x6x6 color cube
+		i -= 16
+		level := func(v int) uint8 {
+			if v == 0 {
+				return 0
+			}
+			return uint8(55 + v*40)
+		}
+		return RGB(level(i/36), level(i/6%6), level(i%6))
+	default:
+		// grayscale ramp
+		v := uint8(8 + (i-232)*10)
+		return RGB(v, v, v)
+	}
+}
+
+// Palette returns the true color that c is displayed as, according to the
+// palette and default colors set by the application.
+func (t *State) Palette(c Color) Color {
+	switch {
+	case c.TrueColor():
+		return c
+	case c.Indexed():
+		return t.palette[c]
+	case c == DefaultBG:
+		return t.defaultBG
+	default:
+		return t.defaultFG
+	}
+}
+
+// CursorColor returns the true color of the cursor.
+func (t *State) CursorColor() Color {
+	return t.cursorColor
+}
+
+func (t *State) paletteChanged() {
+	t.changed |= ChangedPalette
+	t.dirtyAll()
+}
+
+// parseColorSpec parses an X11 color specification as accepted by xterm,
+// either rgb:r/g/b with 1 to 4 hex digits per component, or #rgb with 1 to
+// 4 hex digits per component.
+func parseColorSpec(spec string) (Color, bool) {
+	var parts []string
+	switch {
+	case strings.HasPrefix(spec, "rgb:"):
+		parts = strings.Split(spec[4:], "/")
+		if len(parts) != 3 {
+			return 0, false
+		}
+	case strings.HasPrefix(spec, "#"):
+		spec = spec[1:]
+		n := len(spec) / 3
+		if n == 0 || len(spec)%3 != 0 {
+			return 0, false
+		}
+		parts = []string{spec[:n], spec[n : 2*n], spec[2*n:]}
+	default:
+		return 0, false
+	}
+	var rgb [3]uint8
+	for i, p := range parts {
+		if len(p) < 1 || len(p) > 4 {
+			return 0, false
+		}
+		v, err := strconv.ParseUint(p, 16, 16)
+		if err != nil {
+			return 0, false
+		}
+		// scale to 8 bits
+		max := uint64(1)<<(4*uint(len(p))) - 1
+		rgb[i] = uint8(v * 255 / max)
+	}
+	return RGB(rgb[0], rgb[1], rgb[2]), true
+}
+
+// colorSpec formats c in the form xterm uses to answer color queries.
+func colorSpec(c Color) string {
+	r, g, b := c.RGB()
+	return fmt.Sprintf("rgb:%04x/%04x/%04x",
+		uint16(r)*257, uint16(g)*257, uint16(b)*257)
+}
+
+// setPaletteColors handles OSC 4 with pairs of color index and spec.
+func (t *State) setPaletteColors(args []string) {
+	for i := 0; i+1 < len(args); i += 2 {
+		n, err := strconv.Atoi(args[i])
+		if err != nil || !between(n, 0, 255) {
+			t.logf("bad color index '%s'\n", args[i])
+			return
+		}
+		if args[i+1] == "?" {
+			t.respond("\033]4;%d;%s%s", n, colorSpec(t.palette[n]), t.str.term)
+			continue
+		}
+		c, ok := parseColorSpec(args[i+1])
+		if !ok {
+			t.logf("bad color spec '%s'\n", args[i+1])
+			continue
+		}
+		t.palette[n] = c
+		t.paletteChanged()
+	}
+}
+
+// resetPaletteColors handles OSC 104, resetting all colors if none are
+// given.
+func (t *State) resetPaletteColors(args []string) {
+	if len(args) == 0 || len(args) == 1 && args[0] == "" {
+		for i := range t.palette {
+			t.palette[i] = defaultPaletteColor(i)
+		}
+		t.paletteChanged()
+		return
+	}
+	for _, a := range args {
+		n, err := strconv.Atoi(a)
+		if err != nil || !between(n, 0, 255) {
+			t.logf("bad color index '%s'\n", a)
+			continue
+		}
+		t.palette[n] = defaultPaletteColor(n)
+		t.paletteChanged()
+	}
+}
+
+// dynamicColor returns the dynamic color set by OSC n, from 10 to 12.
+func (t *State) dynamicColor(n int) *Color {
+	switch n {
+	case 10:
+		return &t.defaultFG
+	case 11:
+		return &t.defaultBG
+	default:
+		return &t.cursorColor
+	}
+}
+
+// setDynamicColors handles OSC 10 to 12. As with xterm, additional specs
+// apply to the following dynamic colors.
+func (t *State) setDynamicColors(n int, specs []string) {
+	for _, spec := range specs {
+		if n > 12 {
+			break
+		}
+		c := t.dynamicColor(n)
+		if spec == "?" {
+			t.respond("\033]%d;%s%s", n, colorSpec(*c), t.str.term)
+		} else if v, ok := parseColorSpec(spec); ok {
+			*c = v
+			t.paletteChanged()
+		} else {
+			t.logf("bad color spec '%s'\n", spec)
+		}
+		n++
+	}
+}
+
+// resetDynamicColor handles OSC 110 to 112.
+func (t *State) resetDynamicColor(n int) {
+	c := t.dynamicColor(n)
+	switch n {
+	case 10, 12:
+		*c = defaultPaletteColor(int(LightGrey))
+	case 11:
+		*c = defaultPaletteColor(int(Black))
+	}
+	t.paletteChanged()
+}
@@ -0,0 +1,69 @@
+package terminal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestColorSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		c    Color
+	}{
+		{"rgb:ff/80/0", RGB(255, 128, 0)},
+		{"rgb:ffff/8080/0000", RGB(255, 128, 0)},
+		{"#ff8000", RGB(255, 128, 0)},
+		{"#f80", RGB(255, 136, 0)},
+	}
+	for _, test := range tests {
+		c, ok := parseColorSpec(test.spec)
+		if !ok || c != test.c {
+			t.Fatal(test.spec, c)
+		}
+	}
+	if _, ok := parseColorSpec("rgb:ff/80"); ok {
+		t.Fatal("bad spec parsed")
+	}
+	if colorSpec(RGB(255, 128, 0)) != "rgb:ffff/8080/0000" {
+		t.Fatal(colorSpec(RGB(255, 128, 0)))
+	}
+}
+
+func TestPaletteOSC(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	term.SetResponseWriter(&buf)
+	if st.Palette(Red) != RGB(0xcd, 0, 0) || st.Palette(231) != RGB(255, 255, 255) {
+		t.Fatal(st.Palette(Red), st.Palette(231))
+	}
+
+	st.Lock()
+	st.Unlock()
+	_, err = term.Write([]byte("\033]4;1;#102030;2;?\a\033]11;rgb:1/2/3;?\033\\"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !st.Changed(ChangedPalette) {
+		t.Fatal("palette change not flagged")
+	}
+	if st.Palette(Red) != RGB(0x10, 0x20, 0x30) || st.Palette(DefaultBG) != RGB(0x11, 0x22, 0x33) {
+		t.Fatal(st.Palette(Red), st.Palette(DefaultBG))
+	}
+	expected := "\033]4;2;rgb:0000/cdcd/0000\a\033]12;rgb:e5e5/e5e5/e5e5\033\\"
+	if buf.String() != expected {
+		t.Fatalf("%q", buf.String())
+	}
+
+	_, err = term.Write([]byte("\033]104\a\033]111\a"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if st.Palette(Red) != RGB(0xcd, 0, 0) || st.Palette(DefaultBG) != RGB(0, 0, 0) {
+		t.Fatal(st.Palette(Red), st.Palette(DefaultBG))
+	}
+}
@@ -6,30 +6,78 @@ func isControlCode(c rune) bool {
 
 func (t *State) parse(c rune) {
 	if isControlCode(c) {
-		if t.handleControlCodes(c) || t.cur.attr.mode&attrGfx == 0 {
-			return
-		}
+		t.handleControlCodes(c)
+		return
 	}
+	c = t.translate(c)
 	// TODO: update selection; see st.c:2450
 
+	if x, y, ok := t.prevCell(); ok && t.lines[y].joins(x, c) {
+		t.lines[y].combine(x, c)
+		t.changed |= ChangedScreen
+		t.dirty[y] = true
+		return
+	}
+	width := runeWidth(c)
+	if width == 0 {
+		// nothing to combine with
+		return
+	}
 	if t.mode&ModeWrap != 0 && t.cur.state&cursorWrapNext != 0 {
-		t.lines[t.cur.y][t.cur.x].mode |= attrWrap
+		t.lines[t.cur.y].cells[t.cur.x].mode |= attrWrap
 		t.newline(true)
 	}
 
-	if t.mode&ModeInsert != 0 && t.cur.x+1 < t.cols {
-		// TODO: move shiz, look at st.c:2458
-		t.logln("insert mode not implemented")
+	// lines end at the right margin, unless the cursor is past it
+	end := t.cols
+	if t.cur.x <= t.right {
+		end = t.right + 1
+	}
+
+	if t.mode&ModeInsert != 0 && t.cur.x+width < end {
+		t.insertBlanks(width)
+	}
+
+	// a wide character that does not fit in the last column is moved to
+	// the next line
+	if t.cur.x+width > end {
+		if t.mode&ModeWrap != 0 {
+			t.clear(t.cur.x, t.cur.y, end-1, t.cur.y)
+			t.lines[t.cur.y].cells[end-1].mode |= attrWrap
+			t.newline(true)
+		} else {
+			t.moveTo(end-width, t.cur.y)
+		}
 	}
 
 	t.setChar(c, &t.cur.attr, t.cur.x, t.cur.y)
-	if t.cur.x+1 < t.cols {
-		t.moveTo(t.cur.x+1, t.cur.y)
+	if width == 2 {
+		t.lines[t.cur.y].cells[t.cur.x].mode |= attrWide
+		if t.cur.x+1 < end {
+			t.setChar(0, &t.cur.attr, t.cur.x+1, t.cur.y)
+			t.lines[t.cur.y].cells[t.cur.x+1].mode |= attrWideDummy
+		}
+	}
+	if t.cur.x+width < end {
+		t.moveTo(t.cur.x+width, t.cur.y)
 	} else {
 		t.cur.state |= cursorWrapNext
 	}
 }
 
+// prevCell returns the position of the character left of the cursor, which
+// is the last one printed unless the cursor has since moved.
+func (t *State) prevCell() (x, y int, ok bool) {
+	x, y = t.cur.x, t.cur.y
+	if t.cur.state&cursorWrapNext == 0 {
+		x--
+	}
+	if x > 0 && t.lines[y].cells[x].mode&attrWideDummy != 0 {
+		x--
+	}
+	return x, y, x >= 0
+}
+
 func (t *State) parseEsc(c rune) {
 	if t.handleControlCodes(c) {
 		return
@@ -48,14 +96,25 @@ func (t *State) parseEsc(c rune) {
 		t.str.reset()
 		t.str.typ = c
 		next = t.parseEscStr
-	case '(': // set primary charset G0
-		next = t.parseEscAltCharset
-	case ')', // set secondary charset G1 (ignored)
-		'*', // set tertiary charset G2 (ignored)
-		'+': // set quaternary charset G3 (ignored)
+	case '(', // SCS - set primary charset G0
+		')', // SCS - set secondary charset G1
+		'*', // SCS - set tertiary charset G2
+		'+': // SCS - set quaternary charset G3
+		t.designateG = int(c - '(')
+		next = t.parseEscCharset
+	case 'n': // LS2 - locking shift 2
+		t.cur.gl = 2
+	case 'o': // LS3 - locking shift 3
+		t.cur.gl = 3
+	case 'N': // SS2 - single shift 2
+		t.singleShift = 2
+	case 'O': // SS3 - single shift 3
+		t.singleShift = 3
 	case 'D': // IND - linefeed
 		if t.cur.y == t.bottom {
-			t.scrollUp(t.top, 1)
+			if t.inMargins(t.cur.x) {
+				t.scrollUp(t.top, 1)
+			}
 		} else {
 			t.moveTo(t.cur.x, t.cur.y+1)
 		}
@@ -65,12 +124,14 @@ func (t *State) parseEsc(c rune) {
 		t.tabs[t.cur.x] = true
 	case 'M': // RI - reverse index
 		if t.cur.y == t.top {
-			t.scrollDown(t.top, 1)
+			if t.inMargins(t.cur.x) {
+				t.scrollDown(t.top, 1)
+			}
 		} else {
 			t.moveTo(t.cur.x, t.cur.y-1)
 		}
 	case 'Z': // DECID - identify terminal
-		// TODO: write to our writer our id
+		t.respond(deviceAttrs)
 	case 'c': // RIS - reset to initial state
 		t.reset()
 	case '=': // DECPAM - application keypad
@@ -104,9 +165,15 @@ func (t *State) parseEscStr(c rune) {
 		t.state = t.parseEscStrEnd
 	case '\a': // backwards compatiblity to xterm
 		t.state = t.parse
+		t.str.term = "\a"
 		t.handleSTR()
 	default:
 		t.str.put(c)
+		if t.str.typ == 'P' && c == 'q' && isSixelIntro(t.str.buf) {
+			// sixel data is decoded as it streams in
+			t.sixel.reset(string(t.str.buf))
+			t.state = t.parseSixel
+		}
 	}
 }
 
@@ -116,27 +183,28 @@ func (t *State) parseEscStrEnd(c rune) {
 	}
 	t.state = t.parse
 	if c == '\\' {
+		t.str.term = "\033\\"
 		t.handleSTR()
 	}
 }
 
-func (t *State) parseEscAltCharset(c rune) {
+func (t *State) parseEscCharset(c rune) {
 	if t.handleControlCodes(c) {
 		return
 	}
-	switch c {
-	case '0': // line drawing set
-		t.cur.attr.mode |= attrGfx
-	case 'B': // USASCII
-		t.cur.attr.mode &^= attrGfx
-	case 'A', // UK (ignored)
-		'<', // multinational (ignored)
-		'5', // Finnish (ignored)
-		'C', // Finnish (ignored)
-		'K': // German (ignored)
-	default:
-		t.logf("unknown alt. charset '%c'\n", c)
+	if c == '%' {
+		t.state = t.parseEscCharsetPct
+		return
+	}
+	t.designate(t.designateG, c, false)
+	t.state = t.parse
+}
+
+func (t *State) parseEscCharsetPct(c rune) {
+	if t.handleControlCodes(c) {
+		return
 	}
+	t.designate(t.designateG, c, true)
 	t.state = t.parse
 }
 
@@ -168,23 +236,24 @@ func (t *State) handleControlCodes(c rune) bool {
 		t.moveTo(t.cur.x-1, t.cur.y)
 	// CR
 	case '\r':
-		t.moveTo(0, t.cur.y)
+		t.moveTo(t.lineStart(), t.cur.y)
 	// LF, VT, LF
 	case '\f', '\v', '\n':
 		// go to first col if mode is set
 		t.newline(t.mode&ModeCRLF != 0)
 	// BEL
 	case '\a':
-		// TODO: emit sound
-		// TODO: window alert if not focused
+		t.emit(BellEvent{})
 	// ESC
 	case 033:
 		t.csi.reset()
 		t.state = t.parseEsc
-	// SO, SI
-	case 016, 017:
-		// different charsets not supported. apps should use the correct
-		// alt charset escapes, probably for line drawing
+	// SO - locking shift 1
+	case 016:
+		t.cur.gl = 1
+	// SI - locking shift 0
+	case 017:
+		t.cur.gl = 0
 	// SUB, CAN
 	case 032, 030:
 		t.csi.reset()
@@ -0,0 +1,42 @@
+package terminal
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Paste writes pasted text to the response writer. Newlines are sent as
+// carriage returns, as typed, and control characters other than tab are
+// removed so the text cannot act as commands. With bracketed paste mode,
+// the text is wrapped in markers, and cannot end the paste early.
+func (t *VT) Paste(text string) error {
+	t.dest.lock()
+	mode := t.dest.mode
+	w := t.dest.w
+	t.dest.unlock()
+	if w == nil || mode&ModeKeyboardLock != 0 {
+		return nil
+	}
+	s := sanitizePaste(text)
+	if mode&ModeBracketedPaste != 0 {
+		s = "\033[200~" + s + "\033[201~"
+	}
+	_, err := w.Write([]byte(s))
+	return err
+}
+
+func sanitizePaste(text string) string {
+	text = strings.ReplaceAll(text, "\033[201~", "")
+	text = strings.ReplaceAll(text, "\r\n", "\r")
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c == '\n':
+			return '\r'
+		case c == '\r' || c == '\t':
+			return c
+		case unicode.IsControl(c):
+			return -1
+		}
+		return c
+	}, text)
+}
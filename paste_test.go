@@ -0,0 +1,39 @@
+package terminal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestPaste(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	term.SetResponseWriter(&buf)
+	text := "ls\r\n\tx\n\033[201~rm\x03 \u009b201~y"
+	if err := term.Paste(text); err != nil {
+		t.Fatal(err)
+	}
+	if want := "ls\r\tx\rrm 201~y"; buf.String() != want {
+		t.Fatalf("%q", buf.String())
+	}
+
+	buf.Reset()
+	_, err = term.Write([]byte("\033[?2004h"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !st.Mode(ModeBracketedPaste) {
+		t.Fatal("bracketed paste not set")
+	}
+	if err := term.Paste(text); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\033[200~ls\r\tx\rrm 201~y\033[201~"; buf.String() != want {
+		t.Fatalf("%q", buf.String())
+	}
+}
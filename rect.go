@@ -0,0 +1,184 @@
+package terminal
+
+// rectArgs returns the rectangle given by the top, left, bottom, and right
+// CSI arguments starting at argument i, as screen coordinates. In origin
+// mode, the rectangle is relative to and limited by the margins. If stream
+// is true, the area is a stream of cells from (x0, y0) to (x1, y1) instead.
+func (t *State) rectArgs(i int, stream bool) (x0, y0, x1, y1 int, ok bool) {
+	c := &t.csi
+	minx, miny, maxx, maxy := 0, 0, t.cols-1, t.rows-1
+	if t.cur.state&cursorOrigin != 0 {
+		minx, miny, maxx, maxy = t.left, t.top, t.right, t.bottom
+	}
+	y0 = miny + c.maxarg(i, 1) - 1
+	x0 = minx + c.maxarg(i+1, 1) - 1
+	y1 = maxy
+	x1 = maxx
+	if b := c.arg(i+2, 0); b > 0 {
+		y1 = miny + b - 1
+	}
+	if r := c.arg(i+3, 0); r > 0 {
+		x1 = minx + r - 1
+	}
+	y1 = min(y1, maxy)
+	x1 = min(x1, maxx)
+	if y0 > y1 || x0 > x1 && (!stream || y0 == y1) {
+		return 0, 0, 0, 0, false
+	}
+	return x0, y0, x1, y1, true
+}
+
+// eachRectCell calls fn for each cell x of a line in the rectangle. If stream is true,
+// the first and last lines are instead taken from x0 to the end of the line,
+// and from the start of the line to x1, as with the extent of a selection.
+func (t *State) eachRectCell(x0, y0, x1, y1 int, stream bool, fn func(l *line, x int)) {
+	t.changed |= ChangedScreen
+	for y := y0; y <= y1; y++ {
+		t.dirty[y] = true
+		start, end := x0, x1
+		if stream {
+			if y > y0 {
+				start = 0
+			}
+			if y < y1 {
+				end = t.cols - 1
+			}
+		}
+		for x := start; x <= end; x++ {
+			fn(&t.lines[y], x)
+		}
+	}
+}
+
+func (t *State) copyRect() {
+	x0, y0, x1, y1, ok := t.rectArgs(0, false)
+	if !ok {
+		return
+	}
+	// page arguments 4 and 7 are ignored
+	minx, miny, maxx, maxy := 0, 0, t.cols-1, t.rows-1
+	if t.cur.state&cursorOrigin != 0 {
+		minx, miny, maxx, maxy = t.left, t.top, t.right, t.bottom
+	}
+	dy := miny + t.csi.maxarg(5, 1) - 1
+	dx := minx + t.csi.maxarg(6, 1) - 1
+	if dx > maxx || dy > maxy {
+		return
+	}
+	w := min(x1-x0, maxx-dx) + 1
+	h := min(y1-y0, maxy-dy) + 1
+
+	// buffer the source in case it overlaps the destination
+	buf := make([]line, h)
+	for i := range buf {
+		buf[i] = newLine(w)
+		copyCells(&buf[i], 0, &t.lines[y0+i], x0, w)
+	}
+	t.changed |= ChangedScreen
+	for i := range buf {
+		y := dy + i
+		t.splitWide(dx, y)
+		t.splitWide(dx+w-1, y)
+		copyCells(&t.lines[y], dx, &buf[i], 0, w)
+		// wide characters cut by the rectangle edges
+		t.splitWide(dx, y)
+		t.splitWide(dx+w-1, y)
+		t.dirty[y] = true
+	}
+}
+
+func (t *State) fillRect(c rune) {
+	if !between(int(c), 32, 126) && !between(int(c), 160, 255) {
+		return
+	}
+	x0, y0, x1, y1, ok := t.rectArgs(1, false)
+	if !ok {
+		return
+	}
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			t.setChar(c, &t.cur.attr, x, y)
+		}
+	}
+}
+
+func (t *State) selectiveEraseRect() {
+	x0, y0, x1, y1, ok := t.rectArgs(0, false)
+	if !ok {
+		return
+	}
+	for y := y0; y <= y1; y++ {
+		t.splitWide(x0, y)
+		t.splitWide(x1, y)
+	}
+	t.eachRectCell(x0, y0, x1, y1, false, func(l *line, x int) {
+		if l.cells[x].mode&attrProtected == 0 {
+			l.blank(x)
+		}
+	})
+}
+
+// changeRectAttrs sets, or reverses if rev is true, the SGR attributes given
+// by the CSI arguments after the rectangle. Only bold, underline, blink, and
+// reverse can be changed.
+func (t *State) changeRectAttrs(rev bool) {
+	stream := !t.rectExtent
+	x0, y0, x1, y1, ok := t.rectArgs(0, stream)
+	if !ok {
+		return
+	}
+	args := t.csi.args
+	if len(args) > 4 {
+		args = args[4:]
+	} else {
+		args = []int{0}
+	}
+	var set, clr int16
+	for _, a := range args {
+		switch a {
+		case 0:
+			if rev {
+				set |= attrBold | attrUnderline | attrBlink | attrReverse
+			} else {
+				clr |= attrBold | attrUnderline | attrBlink | attrReverse
+			}
+		case 1:
+			set |= attrBold
+		case 4:
+			set |= attrUnderline
+		case 5:
+			set |= attrBlink
+		case 7:
+			set |= attrReverse
+		case 22:
+			clr |= attrBold
+		case 24:
+			clr |= attrUnderline
+		case 25:
+			clr |= attrBlink
+		case 27:
+			clr |= attrReverse
+		}
+	}
+	if rev {
+		// reverse ignores the attribute reset codes
+		clr = 0
+	}
+	t.eachRectCell(x0, y0, x1, y1, stream, func(l *line, x int) {
+		g := &l.cells[x]
+		mode := g.mode
+		if rev {
+			mode ^= set
+		} else {
+			mode = mode&^clr | set
+		}
+		// colors have bold and reverse applied, as done by setChar
+		if mode&attrBold != 0 && g.mode&attrBold == 0 && g.fg < 8 {
+			g.fg += 8
+		}
+		if (mode^g.mode)&attrReverse != 0 {
+			g.fg, g.bg = g.bg, g.fg
+		}
+		g.mode = mode
+	})
+}
@@ -0,0 +1,55 @@
+package terminal
+
+import (
+	"io"
+	"testing"
+)
+
+func TestRectOps(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.Resize(6, 4)
+	_, err = term.Write([]byte("abcdef\r\nghijkl\r\nmnopqr"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	// copy 2x2 from (1,1) to (3,4), fill a protected rectangle, erase
+	// the rest of its line selectively, and erase a rectangle
+	_, err = term.Write([]byte("\033[1;1;2;2;1;3;4;1$v" +
+		"\033[1\"q\033[43;4;5;4;6$x\033[0\"q" +
+		"\033[4;1;4;6${" +
+		"\033[2;2;2;2$z"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"abcdef",
+		"g ijkl",
+		"mnoabr",
+		"    ++",
+	}
+	for y, line := range expected {
+		if actual := extractStr(&st, 0, 5, y); actual != line {
+			t.Fatal(y, actual)
+		}
+	}
+
+	// reverse video on a stream of cells
+	_, err = term.Write([]byte("\033[1;5;2;2;7$r"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	for x, y := 0, 0; y < 2; x++ {
+		_, fg, _ := st.Cell(x, y)
+		reversed := (y == 0 && x >= 4) || (y == 1 && x <= 1)
+		if (fg == DefaultBG) != reversed {
+			t.Fatal(x, y, fg)
+		}
+		if x == 5 {
+			x, y = -1, y+1
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package terminal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Zone is the semantic zone of a cell, as marked by the shell with OSC 133.
+type Zone uint8
+
+// Semantic zones
+const (
+	ZoneNone Zone = iota
+	ZonePrompt
+	ZoneInput
+	ZoneOutput
+)
+
+// MarkKind is the kind of a semantic mark.
+type MarkKind uint8
+
+// Semantic marks, in the order they occur for a command.
+const (
+	MarkPromptStart MarkKind = iota
+	MarkCommandStart
+	MarkOutputStart
+	MarkCommandFinished
+)
+
+// SemanticMark is where a shell marked the start of a prompt, command, or
+// output, or where a command finished, as the cursor position at the time.
+// Row counts lines of the scrollback history followed by the primary
+// screen, so row HistoryLen() is the top of the screen.
+type SemanticMark struct {
+	Kind     MarkKind
+	Col, Row int
+	ExitCode int // for MarkCommandFinished
+}
+
+// Command is a command block from the start of its prompt to where it
+// finished. Rows are as for SemanticMark. The prompt, command, and output
+// rows are -1 if the shell did not mark them.
+type Command struct {
+	PromptCol, PromptRow   int
+	CommandCol, CommandRow int
+	OutputCol, OutputRow   int
+	EndCol, EndRow         int // where the command finished
+	Finished               bool
+	ExitCode               int
+}
+
+// mark is a semantic mark on a line.
+type mark struct {
+	kind     MarkKind
+	col      int
+	exitCode int
+}
+
+// markZones are the zones that follow each kind of mark.
+var markZones = [...]Zone{ZonePrompt, ZoneInput, ZoneOutput, ZoneNone}
+
+// WorkingDirectory returns the URL of the shell's working directory, as set
+// by OSC 7.
+func (t *State) WorkingDirectory() string {
+	return t.cwd
+}
+
+func (t *State) setWorkingDirectory(url string) {
+	t.changed |= ChangedWorkingDirectory
+	t.cwd = url
+}
+
+// CellZone returns the semantic zone at position (x, y), as set by the
+// last mark before it.
+func (t *State) CellZone(x, y int) Zone {
+	for row := y; ; row-- {
+		var l line
+		switch {
+		case row >= 0:
+			l = t.lines[row]
+		case t.mode&ModeAltScreen == 0 && len(t.history)+row >= 0:
+			l = t.history[len(t.history)+row]
+		default:
+			return ZoneNone
+		}
+		col := -1
+		var kind MarkKind
+		for _, m := range l.marks {
+			if (row < y || m.col <= x) && m.col >= col {
+				col, kind = m.col, m.kind
+			}
+		}
+		if col >= 0 {
+			return markZones[kind]
+		}
+	}
+}
+
+// handleSemanticPrompt handles OSC 133 with its mark and arguments. Marks
+// are kept on the line of the cursor, so they scroll with it.
+func (t *State) handleSemanticPrompt(args []string) {
+	if len(args) == 0 || args[0] == "" {
+		return
+	}
+	m := mark{col: t.cur.x}
+	switch args[0][0] {
+	case 'A': // prompt start
+		m.kind = MarkPromptStart
+	case 'B': // command start
+		m.kind = MarkCommandStart
+	case 'C': // output start
+		m.kind = MarkOutputStart
+	case 'D': // command finished
+		m.kind = MarkCommandFinished
+		if len(args) > 1 {
+			m.exitCode, _ = strconv.Atoi(args[1])
+		}
+	default:
+		t.logf("unknown semantic prompt mark '%s'\n", args[0])
+		return
+	}
+	l := &t.lines[t.cur.y]
+	if m.kind == MarkPromptStart {
+		// a prompt redrawn over an unfinished one replaces its marks
+		done := 0
+		for i, old := range l.marks {
+			if old.kind == MarkCommandFinished {
+				done = i + 1
+			}
+		}
+		keep := l.marks[:done]
+		for _, old := range l.marks[done:] {
+			if old.col < m.col {
+				keep = append(keep, old)
+			}
+		}
+		l.marks = keep
+	}
+	l.marks = append(l.marks, m)
+}
+
+// dropMarks removes the marks of screen rows y0 through y1, which have been
+// erased.
+func (t *State) dropMarks(y0, y1 int) {
+	for y := max(y0, 0); y <= min(y1, t.rows-1); y++ {
+		t.lines[y].marks = nil
+	}
+}
+
+// primaryLine returns a row of the scrollback history followed by the
+// primary screen.
+func (t *State) primaryLine(row int) line {
+	if row < len(t.history) {
+		return t.history[row]
+	}
+	if t.mode&ModeAltScreen != 0 {
+		return t.altLines[row-len(t.history)]
+	}
+	return t.lines[row-len(t.history)]
+}
+
+// Marks returns the semantic marks in the scrollback history and on the
+// primary screen, in order.
+func (t *State) Marks() []SemanticMark {
+	var marks []SemanticMark
+	for row := 0; row < len(t.history)+t.rows; row++ {
+		for _, m := range t.primaryLine(row).marks {
+			marks = append(marks, SemanticMark{m.kind, m.col, row, m.exitCode})
+		}
+	}
+	return marks
+}
+
+// Commands returns the finished command blocks in the scrollback history
+// and on the primary screen, in order.
+func (t *State) Commands() []Command {
+	var cmds []Command
+	var c Command
+	open := false
+	for _, m := range t.Marks() {
+		if !open || m.Kind == MarkPromptStart {
+			c = Command{-1, -1, -1, -1, -1, -1, -1, -1, false, 0}
+			open = true
+		}
+		switch m.Kind {
+		case MarkPromptStart:
+			c.PromptCol, c.PromptRow = m.Col, m.Row
+		case MarkCommandStart:
+			c.CommandCol, c.CommandRow = m.Col, m.Row
+		case MarkOutputStart:
+			c.OutputCol, c.OutputRow = m.Col, m.Row
+		case MarkCommandFinished:
+			c.EndCol, c.EndRow = m.Col, m.Row
+			c.Finished = true
+			c.ExitCode = m.ExitCode
+			cmds = append(cmds, c)
+			open = false
+		}
+	}
+	return cmds
+}
+
+// CommandOutput returns the text output by a command, from the start of
+// its output to where it finished, with lines separated by newlines unless
+// they were wrapped.
+func (t *State) CommandOutput(c Command) string {
+	if c.OutputRow < 0 || c.EndRow < c.OutputRow {
+		return ""
+	}
+	endRow, endCol := c.EndRow, c.EndCol
+	if endCol == 0 && endRow > c.OutputRow {
+		// the output ended with a newline
+		endRow--
+		endCol = -1
+	}
+	var b strings.Builder
+	for row := c.OutputRow; row <= endRow && row < len(t.history)+t.rows; row++ {
+		pl := t.primaryLine(row)
+		l := pl.cells
+		start, end := 0, len(l)
+		if row == c.OutputRow {
+			start = min(c.OutputCol, end)
+		}
+		if row == endRow && endCol >= 0 {
+			end = max(min(endCol, end), start)
+		}
+		var text strings.Builder
+		for x := start; x < end; x++ {
+			text.WriteString(pl.cellString(x))
+		}
+		if row != endRow && len(l) > 0 && l[len(l)-1].mode&attrWrap != 0 {
+			b.WriteString(text.String())
+			continue
+		}
+		b.WriteString(strings.TrimRight(text.String(), " "))
+		if row != endRow {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
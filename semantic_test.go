@@ -0,0 +1,116 @@
+package terminal
+
+import (
+	"io"
+	"testing"
+)
+
+func TestSemanticPrompt(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetHistoryLimit(10)
+	term.Resize(10, 3)
+	_, err = term.Write([]byte("\033]7;file://host/tmp\a" +
+		"\033]133;A\a$ \033]133;B\als\r\n\033]133;C\aa\r\n\r\nbcdefghijkl\r\n\033]133;D;2\a" +
+		"\033]133;A\a$ \033]133;B\a"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !st.Changed(ChangedWorkingDirectory) || st.WorkingDirectory() != "file://host/tmp" {
+		t.Fatal(st.WorkingDirectory())
+	}
+	cmds := st.Commands()
+	if len(cmds) != 1 {
+		t.Fatal(cmds)
+	}
+	c := cmds[0]
+	if !c.Finished || c.ExitCode != 2 || c.PromptRow != 0 || c.CommandCol != 2 || c.OutputRow != 1 {
+		t.Fatal(c)
+	}
+	if out := st.CommandOutput(c); out != "a\n\nbcdefghijkl" {
+		t.Fatalf("%q", out)
+	}
+	marks := st.Marks()
+	expected := []SemanticMark{
+		{MarkPromptStart, 0, 0, 0},
+		{MarkCommandStart, 2, 0, 0},
+		{MarkOutputStart, 0, 1, 0},
+		{MarkCommandFinished, 0, 5, 2},
+		{MarkPromptStart, 0, 5, 0},
+		{MarkCommandStart, 2, 5, 0},
+	}
+	if len(marks) != len(expected) {
+		t.Fatal(marks)
+	}
+	for i := range marks {
+		if marks[i] != expected[i] {
+			t.Fatal(i, marks[i])
+		}
+	}
+}
+
+func TestSemanticPromptRedraw(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.Resize(10, 3)
+	_, err = term.Write([]byte("\033]133;A\a$ \033]133;B\a\r\033[K" +
+		"\033]133;A\a$ \033]133;B\als\r\n\033]133;C\a\033]133;D;0\a" +
+		"\033]133;A\a$ \033]133;B\a\r\033[K$ "))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	cmds := st.Commands()
+	if len(cmds) != 1 {
+		t.Fatal(cmds)
+	}
+	c := cmds[0]
+	if c.PromptCol != 0 || c.PromptRow != 0 || c.CommandCol != 2 || c.CommandRow != 0 ||
+		c.OutputCol != 0 || c.OutputRow != 1 || c.EndCol != 0 || c.EndRow != 1 {
+		t.Fatal(c)
+	}
+	if out := st.CommandOutput(c); out != "" {
+		t.Fatalf("%q", out)
+	}
+	if z := st.CellZone(0, 1); z != ZonePrompt {
+		t.Fatal(z)
+	}
+	if z := st.CellZone(5, 0); z != ZoneInput {
+		t.Fatal(z)
+	}
+}
+
+func TestSemanticPromptEnd(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.Resize(10, 3)
+	_, err = term.Write([]byte("\033]133;A\a$ \033]133;B\acat\r\n\033]133;C\aab\033[4G\033]133;D\a"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	cmds := st.Commands()
+	if len(cmds) != 1 {
+		t.Fatal(cmds)
+	}
+	c := cmds[0]
+	if c.EndCol != 3 || c.EndRow != 1 {
+		t.Fatal(c)
+	}
+	if out := st.CommandOutput(c); out != "ab" {
+		t.Fatalf("%q", out)
+	}
+	if z := st.CellZone(1, 1); z != ZoneOutput {
+		t.Fatal(z)
+	}
+	if z := st.CellZone(5, 1); z != ZoneNone {
+		t.Fatal(z)
+	}
+}
@@ -0,0 +1,271 @@
+package terminal
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// maxSixelSize bounds the width and height of sixel images in pixels.
+const maxSixelSize = 4096
+
+// VT340 default color registers, in percent
+var sixelDefaultColors = [16][3]int{
+	{0, 0, 0}, {20, 20, 80}, {80, 13, 13}, {20, 80, 20},
+	{80, 20, 80}, {20, 80, 80}, {80, 80, 20}, {53, 53, 53},
+	{26, 26, 26}, {33, 33, 60}, {60, 26, 26}, {33, 60, 33},
+	{60, 33, 60}, {33, 60, 60}, {60, 60, 33}, {80, 80, 80},
+}
+
+// sixelDecoder decodes sixel data streamed from a DCS sequence.
+type sixelDecoder struct {
+	img         *image.NRGBA
+	w, h        int // extent of the image so far
+	x, y        int // position of the current sixel
+	colors      [256]color.NRGBA
+	color       int
+	repeat      int
+	transparent bool // untouched pixels are left transparent
+
+	cmd    rune // pending command, one of '!', '"', '#', or 0
+	params []int
+	num    int
+	hasNum bool
+}
+
+// isSixelIntro returns true if buf is the start of a DCS sixel sequence,
+// that is numeric parameters followed by q.
+func isSixelIntro(buf []byte) bool {
+	if len(buf) == 0 || buf[len(buf)-1] != 'q' {
+		return false
+	}
+	for _, c := range buf[:len(buf)-1] {
+		if c != ';' && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *sixelDecoder) reset(params string) {
+	*d = sixelDecoder{params: d.params[:0]}
+	for i, c := range sixelDefaultColors {
+		d.colors[i] = percentRGB(c[0], c[1], c[2])
+	}
+	for i := len(sixelDefaultColors); i < len(d.colors); i++ {
+		d.colors[i] = color.NRGBA{A: 0xff}
+	}
+	// P2 selects whether the background is transparent
+	ps := strings.Split(strings.TrimSuffix(params, "q"), ";")
+	if len(ps) > 1 && ps[1] == "1" {
+		d.transparent = true
+	}
+	d.repeat = 1
+}
+
+func percentRGB(r, g, b int) color.NRGBA {
+	r, g, b = clamp(r, 0, 100), clamp(g, 0, 100), clamp(b, 0, 100)
+	return color.NRGBA{uint8(r * 255 / 100), uint8(g * 255 / 100), uint8(b * 255 / 100), 0xff}
+}
+
+// hlsRGB converts a sixel HLS color, where hue 0 is blue, to RGB.
+func hlsRGB(h, l, s int) color.NRGBA {
+	hue := float64((h+240)%360) / 360
+	light := float64(clamp(l, 0, 100)) / 100
+	sat := float64(clamp(s, 0, 100)) / 100
+	if sat == 0 {
+		v := uint8(light * 255)
+		return color.NRGBA{v, v, v, 0xff}
+	}
+	var q float64
+	if light < 0.5 {
+		q = light * (1 + sat)
+	} else {
+		q = light + sat - light*sat
+	}
+	p := 2*light - q
+	conv := func(t float64) uint8 {
+		if t < 0 {
+			t++
+		} else if t > 1 {
+			t--
+		}
+		var v float64
+		switch {
+		case t < 1.0/6:
+			v = p + (q-p)*6*t
+		case t < 0.5:
+			v = q
+		case t < 2.0/3:
+			v = p + (q-p)*(2.0/3-t)*6
+		default:
+			v = p
+		}
+		return uint8(v*255 + 0.5)
+	}
+	return color.NRGBA{conv(hue + 1.0/3), conv(hue), conv(hue - 1.0/3), 0xff}
+}
+
+func (d *sixelDecoder) put(c rune) {
+	if c >= '0' && c <= '9' {
+		d.num = min(d.num*10+int(c-'0'), 1<<16)
+		d.hasNum = true
+		return
+	}
+	if c == ';' {
+		d.params = append(d.params, d.num)
+		d.num, d.hasNum = 0, false
+		return
+	}
+	d.finishCommand()
+	switch {
+	case c == '!', c == '"', c == '#':
+		d.cmd = c
+	case c == '$': // graphics carriage return
+		d.x = 0
+	case c == '-': // graphics new line
+		d.x = 0
+		d.y += 6
+	case c >= '?' && c <= '~':
+		d.putSixel(int(c - '?'))
+	}
+}
+
+func (d *sixelDecoder) param(i, def int) int {
+	if i >= len(d.params) {
+		return def
+	}
+	return d.params[i]
+}
+
+func (d *sixelDecoder) finishCommand() {
+	if d.hasNum || len(d.params) > 0 {
+		d.params = append(d.params, d.num)
+	}
+	switch d.cmd {
+	case '!': // repeat introducer
+		d.repeat = max(d.param(0, 1), 1)
+	case '"': // raster attributes; only the size is used
+		d.grow(min(d.param(2, 0), maxSixelSize), min(d.param(3, 0), maxSixelSize))
+	case '#': // color introducer
+		n := d.param(0, 0)
+		if n < 0 || n >= len(d.colors) {
+			break
+		}
+		if len(d.params) >= 5 {
+			switch d.params[1] {
+			case 1:
+				d.colors[n] = hlsRGB(d.params[2], d.params[3], d.params[4])
+			case 2:
+				d.colors[n] = percentRGB(d.params[2], d.params[3], d.params[4])
+			}
+		}
+		d.color = n
+	}
+	d.cmd = 0
+	d.params = d.params[:0]
+	d.num, d.hasNum = 0, false
+}
+
+// grow extends the image to at least w by h pixels.
+func (d *sixelDecoder) grow(w, h int) {
+	if w <= d.w && h <= d.h {
+		return
+	}
+	d.w, d.h = max(d.w, w), max(d.h, h)
+	if d.img != nil && d.w <= d.img.Rect.Dx() && d.h <= d.img.Rect.Dy() {
+		return
+	}
+	cw, ch := max(d.w, 64), max(d.h, 64)
+	if d.img != nil {
+		cw = min(max(cw, 2*d.img.Rect.Dx()), maxSixelSize)
+		ch = min(max(ch, 2*d.img.Rect.Dy()), maxSixelSize)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, cw, ch))
+	if d.img != nil {
+		for y := 0; y < d.img.Rect.Dy(); y++ {
+			copy(img.Pix[y*img.Stride:], d.img.Pix[y*d.img.Stride:(y+1)*d.img.Stride])
+		}
+	}
+	d.img = img
+}
+
+func (d *sixelDecoder) putSixel(bits int) {
+	n := d.repeat
+	d.repeat = 1
+	if d.x >= maxSixelSize || d.y >= maxSixelSize {
+		return
+	}
+	n = min(n, maxSixelSize-d.x)
+	if bits != 0 {
+		d.grow(d.x+n, min(d.y+6, maxSixelSize))
+		c := d.colors[d.color]
+		for i := 0; i < 6 && d.y+i < maxSixelSize; i++ {
+			if bits&(1<<uint(i)) == 0 {
+				continue
+			}
+			for x := d.x; x < d.x+n; x++ {
+				d.img.SetNRGBA(x, d.y+i, c)
+			}
+		}
+	}
+	d.x += n
+}
+
+// image returns the decoded image, or nil if nothing was drawn.
+func (d *sixelDecoder) image() image.Image {
+	d.finishCommand()
+	if d.img == nil || d.w == 0 || d.h == 0 {
+		return nil
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, d.w, d.h))
+	for y := 0; y < d.h; y++ {
+		copy(img.Pix[y*img.Stride:], d.img.Pix[y*d.img.Stride:y*d.img.Stride+4*d.w])
+	}
+	if !d.transparent {
+		bg := d.colors[0]
+		for i := 0; i < len(img.Pix); i += 4 {
+			if img.Pix[i+3] == 0 {
+				img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = bg.R, bg.G, bg.B, bg.A
+			}
+		}
+	}
+	d.img = nil
+	return img
+}
+
+func (t *State) parseSixel(c rune) {
+	switch c {
+	case '\033':
+		t.state = t.parseSixelEnd
+	case 030, 032: // CAN, SUB abort the image
+		t.sixel.img = nil
+		t.state = t.parse
+	default:
+		t.sixel.put(c)
+	}
+}
+
+func (t *State) parseSixelEnd(c rune) {
+	if t.handleControlCodes(c) {
+		return
+	}
+	t.state = t.parse
+	if c == '\\' {
+		t.showSixel()
+	}
+}
+
+// showSixel places the decoded image at the cursor, and moves the cursor to
+// the line below it.
+func (t *State) showSixel() {
+	img := t.sixel.image()
+	if img == nil {
+		return
+	}
+	b := img.Bounds()
+	cols := (b.Dx() + t.cellW - 1) / t.cellW
+	rows := (b.Dy() + t.cellH - 1) / t.cellH
+	t.placeImage(img, cols, rows)
+	t.newline(false)
+}
@@ -0,0 +1,66 @@
+package terminal
+
+import (
+	"image/color"
+	"io"
+	"testing"
+)
+
+func TestSixel(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.SetCellSize(2, 6)
+	term.Resize(10, 3)
+	// a 4x12 image: a red top half, and a green column repeated 3 times in
+	// the bottom half over a transparent background
+	_, err = term.Write([]byte("x\033P0;1q\"1;1;4;12#1;2;100;0;0#1!4~-#2;2;0;100;0!3N\033\\"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	imgs := st.Images()
+	if len(imgs) != 1 {
+		t.Fatal(imgs)
+	}
+	p := imgs[0]
+	if p.X != 1 || p.Y != 0 || p.Cols != 2 || p.Rows != 2 {
+		t.Fatal(p)
+	}
+	if b := p.Image.Bounds(); b.Dx() != 4 || b.Dy() != 12 {
+		t.Fatal(b)
+	}
+	red := color.NRGBA{255, 0, 0, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+	tests := []struct {
+		x, y int
+		c    color.NRGBA
+	}{
+		{0, 0, red}, {3, 5, red}, {0, 9, green}, {2, 9, green}, {3, 9, color.NRGBA{}}, {0, 10, color.NRGBA{}},
+	}
+	for _, test := range tests {
+		if c := color.NRGBAModel.Convert(p.Image.At(test.x, test.y)); c != test.c {
+			t.Fatal(test.x, test.y, c)
+		}
+	}
+	if x, y := st.Cursor(); x != 1 || y != 2 {
+		t.Fatal(x, y)
+	}
+	if _, col, row, ok := st.CellImage(2, 1); !ok || col != 1 || row != 1 {
+		t.Fatal(col, row, ok)
+	}
+
+	// the image scrolls with its cells, and text overwrites it
+	_, err = term.Write([]byte("\n\033[1;2Hy"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	imgs = st.Images()
+	if len(imgs) != 1 || imgs[0].Y != -1 {
+		t.Fatal(imgs)
+	}
+	if _, _, _, ok := st.CellImage(1, 0); ok {
+		t.Fatal("text did not overwrite image")
+	}
+}
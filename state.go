@@ -1,22 +1,41 @@
 package terminal
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"sync"
+	"time"
 )
 
 const (
 	tabspaces = 8
 )
 
+// syncTimeout bounds how long synchronized output holds back changes, in
+// case the application never ends the frame. It is a variable for tests.
+var syncTimeout = time.Second
+
+// deviceAttrs is the primary device attributes reply: a VT220 with sixel
+// graphics, selective erase, ANSI color, and rectangular editing.
+const deviceAttrs = "\033[?62;4;6;22;28c"
+
+// Default cell size in pixels, as reported to the pty.
+const (
+	defaultCellWidth  = 16
+	defaultCellHeight = 16
+)
+
 const (
 	attrReverse = 1 << iota
 	attrUnderline
 	attrBold
-	attrGfx
 	attrItalic
 	attrBlink
 	attrWrap
+	attrWide      // first cell of a wide character
+	attrWideDummy // second cell of a wide character
+	attrProtected // not erased by selective erase
 )
 
 const (
@@ -49,7 +68,14 @@ const (
 	ModeFocus
 	ModeMouseX10
 	ModeMouseMany
-	ModeMouseMask = ModeMouseButton | ModeMouseMotion | ModeMouseX10 | ModeMouseMany
+	ModeLeftRightMargin
+	ModeMouseUtf8
+	ModeMouseUrxvt
+	ModeMouseSgrPixels
+	ModeBracketedPaste
+	ModeSyncOutput
+	ModeMouseMask         = ModeMouseButton | ModeMouseMotion | ModeMouseX10 | ModeMouseMany
+	ModeMouseEncodingMask = ModeMouseUtf8 | ModeMouseSgr | ModeMouseUrxvt | ModeMouseSgrPixels
 )
 
 // ChangeFlag represents possible state changes of the terminal.
@@ -59,6 +85,9 @@ type ChangeFlag uint32
 const (
 	ChangedScreen ChangeFlag = 1 << iota
 	ChangedTitle
+	ChangedPalette
+	ChangedWorkingDirectory
+	ChangedUserVars
 )
 
 type glyph struct {
@@ -67,12 +96,104 @@ type glyph struct {
 	fg, bg Color
 }
 
-type line []glyph
+// line is a row of cells, along with data kept for the row as a whole.
+type line struct {
+	cells  []glyph
+	extras map[int]cellExt // extra data of cells, by column
+	marks  []mark          // semantic marks, in the order received
+}
+
+// cellExt is data of a cell that is rarely set, kept apart from glyph so
+// that lines stay small.
+type cellExt struct {
+	comb string // combining characters following the character
+	link uint32 // index of an interned hyperlink, if not 0
+	img  uint32 // image placed over the cell, if not 0
+	imgX uint16 // column of the cell within the image
+	imgY uint16 // row of the cell within the image
+}
+
+func newLine(cols int) line {
+	return line{cells: make([]glyph, cols)}
+}
+
+// clone returns a deep copy of l.
+func (l line) clone() line {
+	l.cells = append([]glyph(nil), l.cells...)
+	l.marks = append([]mark(nil), l.marks...)
+	if l.extras != nil {
+		extras := make(map[int]cellExt, len(l.extras))
+		for x, e := range l.extras {
+			extras[x] = e
+		}
+		l.extras = extras
+	}
+	return l
+}
+
+// recycle drops the data of a line that is reused for new content.
+func (l *line) recycle() {
+	l.extras = nil
+	l.marks = nil
+}
+
+// ext returns the extra data of cell x.
+func (l *line) ext(x int) cellExt {
+	return l.extras[x]
+}
+
+// setExt sets the extra data of cell x.
+func (l *line) setExt(x int, e cellExt) {
+	if e == (cellExt{}) {
+		delete(l.extras, x)
+		return
+	}
+	if l.extras == nil {
+		l.extras = make(map[int]cellExt)
+	}
+	l.extras[x] = e
+}
+
+// blank replaces the character of cell x with a space, keeping its
+// attributes.
+func (l *line) blank(x int) {
+	g := &l.cells[x]
+	g.c = ' '
+	g.mode &^= attrWide | attrWideDummy
+	l.setExt(x, cellExt{})
+}
+
+// copyCells copies n cells of src starting at column sx to dst at column
+// dx, along with their extra data. The lines may be the same.
+func copyCells(dst *line, dx int, src *line, sx, n int) {
+	type moved struct {
+		x int
+		e cellExt
+	}
+	var extras []moved
+	for x, e := range src.extras {
+		if x >= sx && x < sx+n {
+			extras = append(extras, moved{x - sx + dx, e})
+		}
+	}
+	for x := range dst.extras {
+		if x >= dx && x < dx+n {
+			delete(dst.extras, x)
+		}
+	}
+	copy(dst.cells[dx:dx+n], src.cells[sx:sx+n])
+	for _, m := range extras {
+		dst.setExt(m.x, m.e)
+	}
+}
 
 type cursor struct {
-	attr  glyph
-	x, y  int
-	state uint8
+	attr     glyph
+	link     uint32 // hyperlink of printed characters, if not 0
+	x, y     int
+	state    uint8
+	charsets [4]charset // G0-G3
+	gl       int        // charset invoked into GL
 }
 
 type parseState func(c rune)
@@ -82,6 +203,18 @@ type parseState func(c rune)
 type State struct {
 	DebugLogger *log.Logger
 
+	// Clipboard receives OSC 52 clipboard requests, if set.
+	Clipboard Clipboard
+
+	// ClipboardRead allows applications to read the clipboard with OSC 52
+	// queries. It is off by default, since anything written to the
+	// terminal, such as the contents of a file, could otherwise obtain it.
+	ClipboardRead bool
+
+	// EventHandler, if set, receives bell, notification, and progress
+	// events. It is called with the State locked.
+	EventHandler func(ev Event)
+
 	mu            sync.Mutex
 	changed       ChangeFlag
 	cols, rows    int
@@ -91,13 +224,51 @@ type State struct {
 	anydirty      bool
 	cur, curSaved cursor
 	top, bottom   int // scroll limits
+	left, right   int // horizontal margins
 	mode          ModeFlag
 	state         parseState
 	str           strEscape
 	csi           csiEscape
+	designateG    int  // charset being designated by SCS
+	singleShift   int  // charset selected for the next character only
+	rectExtent    bool // DECSACE; attribute changes apply to a rectangle
 	numlock       bool
+	focused       bool
+	cursorShape   CursorShape
+	cursorBlink   bool
+	syncStart     time.Time // when synchronized output began
 	tabs          []bool
 	title         string
+	history       []line // primary screen scrollback, oldest first
+	historyLimit  int
+	viewOffset    int
+	w             io.Writer // responses to the application
+	out           []byte    // responses not yet written to w
+	palette       [256]Color
+	defaultFG     Color
+	defaultBG     Color
+	cursorColor   Color
+	links         []Hyperlink // interned hyperlinks, 0 is none
+	linkIDs       map[Hyperlink]uint32
+	linkGC        int // size of links that triggers collection
+	cwd           string
+	userVars      map[string]string
+	cellW, cellH  int // cell size in pixels
+	mouseCol      int // cell of the last reported mouse event
+	mouseRow      int
+	keyFlags      [2][]int // kitty keyboard flags stacks of each screen
+	modOtherKeys  int
+	sixel         sixelDecoder
+	images        map[uint32]*imageInfo
+	nextImage     uint32
+	imageGC       int // size of images that triggers collection
+
+	kittyImages     map[int]*kittyImage
+	kittyBytes      int // pixel data of kittyImages
+	kittySeq        int // transmissions of kitty images
+	kittyPlacements []kittyPlacement
+	kittyTransfer   *kittyTransfer
+	kittyNextID     int
 }
 
 func (t *State) logf(format string, args ...interface{}) {
@@ -112,6 +283,16 @@ func (t *State) logln(s string) {
 	}
 }
 
+// respond queues a reply to a query from the application. Replies are
+// written by VT once the state is unlocked, so that an application that
+// does not read them cannot block the state.
+func (t *State) respond(format string, args ...interface{}) {
+	if t.w == nil {
+		return
+	}
+	t.out = fmt.Appendf(t.out, format, args...)
+}
+
 func (t *State) lock() {
 	t.mu.Lock()
 }
@@ -125,16 +306,29 @@ func (t *State) Lock() {
 	t.mu.Lock()
 }
 
-// Unlock resets change flags and unlocks the state object's mutex.
+// Unlock resets change flags and unlocks the state object's mutex. While
+// the application is drawing a synchronized frame, changes are kept for
+// when the frame ends.
 func (t *State) Unlock() {
-	t.resetChanges()
+	if !t.syncing() {
+		t.resetChanges()
+	}
 	t.mu.Unlock()
 }
 
 // Cell returns the character code, foreground color, and background
-// color at position (x, y) relative to the top left of the terminal.
+// color at position (x, y) relative to the top left of the terminal. A
+// wide character occupies two cells, the second of which has the character
+// code 0.
 func (t *State) Cell(x, y int) (ch rune, fg Color, bg Color) {
-	return t.lines[y][x].c, Color(t.lines[y][x].fg), Color(t.lines[y][x].bg)
+	return t.lines[y].cells[x].c, Color(t.lines[y].cells[x].fg), Color(t.lines[y].cells[x].bg)
+}
+
+// CellString returns the full grapheme cluster at position (x, y), that is
+// the character code along with any combining characters. It returns an
+// empty string for the second cell of a wide character.
+func (t *State) CellString(x, y int) string {
+	return t.lines[y].cellString(x)
 }
 
 // Cursor returns the current position of the cursor.
@@ -152,6 +346,51 @@ func (t *State) Mode(mode ModeFlag) bool {
 	return t.mode&mode != 0
 }
 
+// CursorShape is the shape of the cursor.
+type CursorShape uint8
+
+// Cursor shapes
+const (
+	CursorBlock CursorShape = iota
+	CursorUnderline
+	CursorBar
+)
+
+// CursorStyle returns the shape of the cursor and whether it blinks, as set
+// by the application.
+func (t *State) CursorStyle() (shape CursorShape, blink bool) {
+	return t.cursorShape, t.cursorBlink
+}
+
+// setCursorStyle handles DECSCUSR, where 0 and 1 are a blinking block,
+// 2 is a steady block, then underline and bar alternate likewise.
+func (t *State) setCursorStyle(ps int) {
+	if ps < 0 || ps > 6 {
+		t.logf("unknown cursor style %d\n", ps)
+		return
+	}
+	if ps == 0 {
+		ps = 1
+	}
+	t.cursorShape = CursorShape((ps - 1) / 2)
+	t.cursorBlink = ps%2 == 1
+}
+
+// cursorStyleParam returns the DECSCUSR parameter of the cursor style.
+func (t *State) cursorStyleParam() int {
+	ps := int(t.cursorShape)*2 + 1
+	if !t.cursorBlink {
+		ps++
+	}
+	return ps
+}
+
+// Focused returns whether the terminal has focus, as last set with
+// VT.SetFocus.
+func (t *State) Focused() bool {
+	return t.focused
+}
+
 // Title returns the current title set via the tty.
 func (t *State) Title() string {
 	return t.title
@@ -164,9 +403,16 @@ func (t *State) ChangeMask() ChangeFlag {
 }
 */
 
-// Changed returns true if change has occured.
+// Changed returns true if change has occured. Changes are not reported
+// while the application is drawing a synchronized frame.
 func (t *State) Changed(change ChangeFlag) bool {
-	return t.changed&change != 0
+	return t.changed&change != 0 && !t.syncing()
+}
+
+// syncing returns whether the application is drawing a synchronized frame
+// that has not timed out.
+func (t *State) syncing() bool {
+	return t.mode&ModeSyncOutput != 0 && time.Since(t.syncStart) < syncTimeout
 }
 
 // resetChanges resets the change mask and dirtiness.
@@ -194,10 +440,14 @@ func (t *State) put(c rune) {
 func (t *State) putTab(forward bool) {
 	x := t.cur.x
 	if forward {
-		if x == t.cols {
+		end := t.cols
+		if x <= t.right {
+			end = t.right + 1
+		}
+		if x >= end-1 {
 			return
 		}
-		for x++; x < t.cols && !t.tabs[x]; x++ {
+		for x++; x < end-1 && !t.tabs[x]; x++ {
 		}
 	} else {
 		if x == 0 {
@@ -212,49 +462,69 @@ func (t *State) putTab(forward bool) {
 func (t *State) newline(firstCol bool) {
 	y := t.cur.y
 	if y == t.bottom {
-		cur := t.cur
-		t.cur = t.defaultCursor()
-		t.scrollUp(t.top, 1)
-		t.cur = cur
+		if t.inMargins(t.cur.x) {
+			cur := t.cur
+			t.cur = t.defaultCursor()
+			t.scrollUp(t.top, 1)
+			t.cur = cur
+		}
 	} else {
 		y++
 	}
 	if firstCol {
-		t.moveTo(0, y)
+		t.moveTo(t.lineStart(), y)
 	} else {
 		t.moveTo(t.cur.x, y)
 	}
 }
 
-// table from st, which in turn is from rxvt :)
-var gfxCharTable = [62]rune{
-	'↑', '↓', '→', '←', '█', '▚', '☃', // A - G
-	0, 0, 0, 0, 0, 0, 0, 0, // H - O
-	0, 0, 0, 0, 0, 0, 0, 0, // P - W
-	0, 0, 0, 0, 0, 0, 0, ' ', // X - _
-	'◆', '▒', '␉', '␌', '␍', '␊', '°', '±', // ` - g
-	'␤', '␋', '┘', '┐', '┌', '└', '┼', '⎺', // h - o
-	'⎻', '─', '⎼', '⎽', '├', '┤', '┴', '┬', // p - w
-	'│', '≤', '≥', 'π', '≠', '£', '·', // x - ~
+// inMargins returns true if column x is within the left and right margins.
+func (t *State) inMargins(x int) bool {
+	return x >= t.left && x <= t.right
 }
 
-func (t *State) setChar(c rune, attr *glyph, x, y int) {
-	if attr.mode&attrGfx != 0 {
-		if c >= 0x41 && c <= 0x7e && gfxCharTable[c-0x41] != 0 {
-			c = gfxCharTable[c-0x41]
-		}
+// lineStart returns the column a carriage return moves the cursor to.
+func (t *State) lineStart() int {
+	if t.cur.x < t.left {
+		return 0
 	}
+	return t.left
+}
+
+func (t *State) setChar(c rune, attr *glyph, x, y int) {
 	t.changed |= ChangedScreen
 	t.dirty[y] = true
-	t.lines[y][x] = *attr
-	t.lines[y][x].c = c
+	t.splitWide(x, y)
+	t.lines[y].cells[x] = *attr
+	t.lines[y].cells[x].c = c
+	t.lines[y].setExt(x, cellExt{link: t.cur.link})
 	//if t.options.BrightBold && attr.mode&attrBold != 0 && attr.fg < 8 {
 	if attr.mode&attrBold != 0 && attr.fg < 8 {
-		t.lines[y][x].fg = attr.fg + 8
+		t.lines[y].cells[x].fg = attr.fg + 8
 	}
 	if attr.mode&attrReverse != 0 {
-		t.lines[y][x].fg = attr.bg
-		t.lines[y][x].bg = attr.fg
+		t.lines[y].cells[x].fg = attr.bg
+		t.lines[y].cells[x].bg = attr.fg
+	}
+}
+
+// splitWide blanks both halves of a wide character at (x, y), so that
+// either cell can be overwritten or moved on its own. Combining characters
+// of the wide character go with it, and a half whose other half is missing
+// is blanked alone.
+func (t *State) splitWide(x, y int) {
+	l := &t.lines[y]
+	switch {
+	case l.cells[x].mode&attrWide != 0:
+		l.blank(x)
+		if x+1 < t.cols && l.cells[x+1].mode&attrWideDummy != 0 {
+			l.blank(x + 1)
+		}
+	case l.cells[x].mode&attrWideDummy != 0:
+		l.blank(x)
+		if x > 0 && l.cells[x-1].mode&attrWide != 0 {
+			l.blank(x - 1)
+		}
 	}
 }
 
@@ -267,6 +537,7 @@ func (t *State) defaultCursor() cursor {
 
 func (t *State) reset() {
 	t.cur = t.defaultCursor()
+	t.singleShift = 0
 	t.saveCursor()
 	for i := range t.tabs {
 		t.tabs[i] = false
@@ -276,11 +547,49 @@ func (t *State) reset() {
 	}
 	t.top = 0
 	t.bottom = t.rows - 1
+	t.left = 0
+	t.right = t.cols - 1
 	t.mode = ModeWrap
+	t.rectExtent = false
+	t.cursorShape = CursorBlock
+	t.cursorBlink = false
+	t.mouseCol, t.mouseRow = -1, -1
+	t.keyFlags = [2][]int{}
+	t.modOtherKeys = 0
+	t.resetPalette()
+	t.kittyImages = nil
+	t.kittyBytes = 0
+	t.kittyPlacements = nil
+	t.kittyTransfer = nil
 	t.clear(0, 0, t.rows-1, t.cols-1)
+	t.dropMarks(0, t.rows-1)
 	t.moveTo(0, 0)
 }
 
+// softReset handles DECSTR, which resets modes and the cursor but keeps the
+// screen.
+func (t *State) softReset() {
+	t.mode &^= ModeInsert | ModeAppKeypad | ModeAppCursor | ModeHide | ModeKeyboardLock
+	t.mode |= ModeWrap
+	t.cur.state &^= cursorOrigin
+	t.cur.attr = t.defaultCursor().attr
+	t.cur.link = 0
+	t.cur.charsets = [4]charset{}
+	t.cur.gl = 0
+	t.singleShift = 0
+	t.top = 0
+	t.bottom = t.rows - 1
+	t.left = 0
+	t.right = t.cols - 1
+	t.mode &^= ModeLeftRightMargin
+	t.rectExtent = false
+	t.cursorShape = CursorBlock
+	t.cursorBlink = false
+	saved := t.cur
+	saved.x, saved.y = 0, 0
+	t.curSaved = saved
+}
+
 // TODO: definitely can improve allocs
 func (t *State) resize(cols, rows int) bool {
 	if cols == t.cols && rows == t.rows {
@@ -291,6 +600,13 @@ func (t *State) resize(cols, rows int) bool {
 	}
 	slide := t.cur.y - rows + 1
 	if slide > 0 {
+		primary := t.lines
+		if t.mode&ModeAltScreen != 0 {
+			primary = t.altLines
+		}
+		for i := 0; i < slide; i++ {
+			t.pushHistory(primary[i])
+		}
 		copy(t.lines, t.lines[slide:slide+rows])
 		copy(t.altLines, t.altLines[slide:slide+rows])
 	}
@@ -306,12 +622,24 @@ func (t *State) resize(cols, rows int) bool {
 	t.changed |= ChangedScreen
 	for i := 0; i < rows; i++ {
 		t.dirty[i] = true
-		t.lines[i] = make(line, cols)
-		t.altLines[i] = make(line, cols)
+		t.lines[i] = newLine(cols)
+		t.altLines[i] = newLine(cols)
 	}
 	for i := 0; i < minrows; i++ {
-		copy(t.lines[i], lines[i])
-		copy(t.altLines[i], altLines[i])
+		copy(t.lines[i].cells, lines[i].cells)
+		copy(t.altLines[i].cells, altLines[i].cells)
+		for x, e := range lines[i].extras {
+			if x < cols {
+				t.lines[i].setExt(x, e)
+			}
+		}
+		for x, e := range altLines[i].extras {
+			if x < cols {
+				t.altLines[i].setExt(x, e)
+			}
+		}
+		t.lines[i].marks = lines[i].marks
+		t.altLines[i].marks = altLines[i].marks
 	}
 	copy(t.tabs, tabs)
 	if cols > t.cols {
@@ -327,6 +655,7 @@ func (t *State) resize(cols, rows int) bool {
 	t.cols = cols
 	t.rows = rows
 	t.setScroll(0, rows-1)
+	t.setMargins(0, cols-1)
 	t.moveTo(t.cur.x, t.cur.y)
 	for i := 0; i < 2; i++ {
 		if mincols < cols && minrows > 0 {
@@ -354,9 +683,12 @@ func (t *State) clear(x0, y0, x1, y1 int) {
 	t.changed |= ChangedScreen
 	for y := y0; y <= y1; y++ {
 		t.dirty[y] = true
+		t.splitWide(x0, y)
+		t.splitWide(x1, y)
 		for x := x0; x <= x1; x++ {
-			t.lines[y][x] = t.cur.attr
-			t.lines[y][x].c = ' '
+			t.lines[y].cells[x] = t.cur.attr
+			t.lines[y].cells[x].c = ' '
+			t.lines[y].setExt(x, cellExt{})
 		}
 	}
 }
@@ -367,21 +699,26 @@ func (t *State) clearAll() {
 
 func (t *State) moveAbsTo(x, y int) {
 	if t.cur.state&cursorOrigin != 0 {
+		x += t.left
 		y += t.top
 	}
 	t.moveTo(x, y)
 }
 
 func (t *State) moveTo(x, y int) {
-	var miny, maxy int
+	var minx, maxx, miny, maxy int
 	if t.cur.state&cursorOrigin != 0 {
+		minx = t.left
+		maxx = t.right
 		miny = t.top
 		maxy = t.bottom
 	} else {
+		minx = 0
+		maxx = t.cols - 1
 		miny = 0
 		maxy = t.rows - 1
 	}
-	x = clamp(x, 0, t.cols-1)
+	x = clamp(x, minx, maxx)
 	y = clamp(y, miny, maxy)
 	t.changed |= ChangedScreen
 	t.cur.state &^= cursorWrapNext
@@ -412,6 +749,21 @@ func (t *State) setScroll(top, bottom int) {
 	t.bottom = bottom
 }
 
+func (t *State) setMargins(left, right int) {
+	left = clamp(left, 0, t.cols-1)
+	right = clamp(right, 0, t.cols-1)
+	if left >= right && t.cols > 1 {
+		return
+	}
+	t.left = left
+	t.right = right
+}
+
+// fullMargins returns true if the left and right margins span the screen.
+func (t *State) fullMargins() bool {
+	return t.left == 0 && t.right == t.cols-1
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -444,8 +796,18 @@ func between(val, min, max int) bool {
 
 func (t *State) scrollDown(orig, n int) {
 	n = clamp(n, 0, t.bottom-orig+1)
-	t.clear(0, t.bottom-n+1, t.cols-1, t.bottom)
 	t.changed |= ChangedScreen
+	if !t.fullMargins() {
+		for i := t.bottom; i >= orig+n; i-- {
+			t.moveMargins(i, i-n)
+		}
+		t.clear(t.left, orig, t.right, orig+n-1)
+		return
+	}
+	t.clear(0, t.bottom-n+1, t.cols-1, t.bottom)
+	for i := t.bottom - n + 1; i <= t.bottom; i++ {
+		t.lines[i].recycle()
+	}
 	for i := t.bottom; i >= orig+n; i-- {
 		t.lines[i], t.lines[i-n] = t.lines[i-n], t.lines[i]
 		t.dirty[i] = true
@@ -457,8 +819,23 @@ func (t *State) scrollDown(orig, n int) {
 
 func (t *State) scrollUp(orig, n int) {
 	n = clamp(n, 0, t.bottom-orig+1)
-	t.clear(0, orig, t.cols-1, orig+n-1)
 	t.changed |= ChangedScreen
+	if !t.fullMargins() {
+		for i := orig; i <= t.bottom-n; i++ {
+			t.moveMargins(i, i+n)
+		}
+		t.clear(t.left, t.bottom-n+1, t.right, t.bottom)
+		return
+	}
+	if orig == 0 && t.mode&ModeAltScreen == 0 {
+		for i := 0; i < n; i++ {
+			t.pushHistory(t.lines[i])
+		}
+	}
+	t.clear(0, orig, t.cols-1, orig+n-1)
+	for i := orig; i < orig+n; i++ {
+		t.lines[i].recycle()
+	}
 	for i := orig; i <= t.bottom-n; i++ {
 		t.lines[i], t.lines[i+n] = t.lines[i+n], t.lines[i]
 		t.dirty[i] = true
@@ -468,6 +845,17 @@ func (t *State) scrollUp(orig, n int) {
 	// TODO: selection scroll
 }
 
+// moveMargins copies the part of line src within the left and right margins
+// to line dst.
+func (t *State) moveMargins(dst, src int) {
+	t.splitWide(t.left, src)
+	t.splitWide(t.right, src)
+	t.splitWide(t.left, dst)
+	t.splitWide(t.right, dst)
+	copyCells(&t.lines[dst], t.left, &t.lines[src], t.left, t.right+1-t.left)
+	t.dirty[dst] = true
+}
+
 func (t *State) modMode(set bool, bit ModeFlag) {
 	if set {
 		t.mode |= bit
@@ -476,6 +864,15 @@ func (t *State) modMode(set bool, bit ModeFlag) {
 	}
 }
 
+// setMouseEncoding sets or resets a mouse encoding mode; only one is active
+// at a time.
+func (t *State) setMouseEncoding(set bool, bit ModeFlag) {
+	if set {
+		t.mode &^= ModeMouseEncodingMask
+	}
+	t.modMode(set, bit)
+}
+
 func (t *State) setMode(priv bool, set bool, args []int) {
 	if priv {
 		for _, a := range args {
@@ -497,6 +894,11 @@ func (t *State) setMode(priv bool, set bool, args []int) {
 				t.moveAbsTo(0, 0)
 			case 7: // DECAWM - auto wrap
 				t.modMode(set, ModeWrap)
+			case 69: // DECLRMM - left right margin mode
+				t.modMode(set, ModeLeftRightMargin)
+				if !set {
+					t.setMargins(0, t.cols-1)
+				}
 			// IGNORED:
 			case 0, // error
 				2,  // DECANM - ANSI/VT52
@@ -505,9 +907,10 @@ func (t *State) setMode(priv bool, set bool, args []int) {
 				8,  // DECARM - auto repeat
 				18, // DECPFF - printer feed
 				19, // DECPEX - printer extent
-				42, // DECNRCM - national characters
-				12: // att610 - start blinking cursor
+				42: // DECNRCM - national characters
 				break
+			case 12: // att610 - start blinking cursor
+				t.cursorBlink = set
 			case 25: // DECTCEM - text cursor enable mode
 				t.modMode(!set, ModeHide)
 			case 9: // X10 mouse compatibility mode
@@ -524,8 +927,21 @@ func (t *State) setMode(priv bool, set bool, args []int) {
 				t.modMode(set, ModeMouseMany)
 			case 1004: // send focus events to tty
 				t.modMode(set, ModeFocus)
+			case 1005: // utf8 mouse mode
+				t.setMouseEncoding(set, ModeMouseUtf8)
 			case 1006: // extended reporting mode
-				t.modMode(set, ModeMouseSgr)
+				t.setMouseEncoding(set, ModeMouseSgr)
+			case 1015: // urxvt mouse mode
+				t.setMouseEncoding(set, ModeMouseUrxvt)
+			case 1016: // extended reporting mode in pixels
+				t.setMouseEncoding(set, ModeMouseSgrPixels)
+			case 2004: // bracketed paste
+				t.modMode(set, ModeBracketedPaste)
+			case 2026: // synchronized output
+				if set && t.mode&ModeSyncOutput == 0 {
+					t.syncStart = time.Now()
+				}
+				t.modMode(set, ModeSyncOutput)
 			case 1034:
 				t.modMode(set, Mode8bit)
 			case 1049, // = 1047 and 1048
@@ -550,12 +966,6 @@ func (t *State) setMode(priv bool, set bool, args []int) {
 			case 1001:
 				// mouse highlight mode; can hang the terminal by design when
 				// implemented
-			case 1005:
-				// utf8 mouse mode; will confuse applications not supporting
-				// utf8 and luit
-			case 1015:
-				// urxvt mangled mouse mode; incompatiblt and can be mistaken
-				// for other control codes
 			default:
 				t.logf("unknown private set/reset mode %d\n", a)
 			}
@@ -568,7 +978,6 @@ func (t *State) setMode(priv bool, set bool, args []int) {
 				t.modMode(set, ModeKeyboardLock)
 			case 4: // IRM - insertion-replacement
 				t.modMode(set, ModeInsert)
-				t.logln("insert mode not implemented")
 			case 12: // SRM - send/receive
 				t.modMode(set, ModeEcho)
 			case 20: // LNM - linefeed/newline
@@ -584,6 +993,66 @@ func (t *State) setMode(priv bool, set bool, args []int) {
 	}
 }
 
+// privateModes maps DEC private modes to the flags reported for them by
+// DECRQM.
+var privateModes = map[int]ModeFlag{
+	1:    ModeAppCursor,
+	5:    ModeReverse,
+	7:    ModeWrap,
+	9:    ModeMouseX10,
+	47:   ModeAltScreen,
+	69:   ModeLeftRightMargin,
+	1000: ModeMouseButton,
+	1002: ModeMouseMotion,
+	1003: ModeMouseMany,
+	1004: ModeFocus,
+	1005: ModeMouseUtf8,
+	1006: ModeMouseSgr,
+	1015: ModeMouseUrxvt,
+	1016: ModeMouseSgrPixels,
+	1047: ModeAltScreen,
+	1049: ModeAltScreen,
+	2004: ModeBracketedPaste,
+	2026: ModeSyncOutput,
+}
+
+// ansiModes maps ANSI modes to the flags reported for them by DECRQM.
+var ansiModes = map[int]ModeFlag{
+	2:  ModeKeyboardLock,
+	4:  ModeInsert,
+	12: ModeEcho,
+	20: ModeCRLF,
+}
+
+// reportMode handles DECRQM, answering whether a mode is set (1), reset
+// (2) or not recognized (0).
+func (t *State) reportMode(priv bool, a int) {
+	modes, prefix := ansiModes, ""
+	if priv {
+		modes, prefix = privateModes, "?"
+	}
+	var set bool
+	bit, ok := modes[a]
+	switch {
+	case ok:
+		set = t.mode&bit != 0
+	case priv && a == 6: // DECOM
+		set, ok = t.cur.state&cursorOrigin != 0, true
+	case priv && a == 12: // att610
+		set, ok = t.cursorBlink, true
+	case priv && a == 25: // DECTCEM
+		set, ok = t.mode&ModeHide == 0, true
+	}
+	pm := 0
+	if ok {
+		pm = 2
+		if set {
+			pm = 1
+		}
+	}
+	t.respond("\033[%s%d;%d$y", prefix, a, pm)
+}
+
 func (t *State) setAttr(attr []int) {
 	if len(attr) == 0 {
 		attr = []int{0}
@@ -616,29 +1085,23 @@ func (t *State) setAttr(attr []int) {
 		case 27:
 			t.cur.attr.mode &^= attrReverse
 		case 38:
-			if i+2 < len(attr) && attr[i+1] == 5 {
-				i += 2
-				if between(attr[i], 0, 255) {
-					t.cur.attr.fg = Color(attr[i])
-				} else {
-					t.logf("bad fgcolor %d\n", attr[i])
-				}
+			c, n, ok := t.extColor(attr[i+1:])
+			if ok {
+				t.cur.attr.fg = c
 			} else {
 				t.logf("gfx attr %d unknown\n", a)
 			}
+			i += n
 		case 39:
 			t.cur.attr.fg = DefaultFG
 		case 48:
-			if i+2 < len(attr) && attr[i+1] == 5 {
-				i += 2
-				if between(attr[i], 0, 255) {
-					t.cur.attr.bg = Color(attr[i])
-				} else {
-					t.logf("bad bgcolor %d\n", attr[i])
-				}
+			c, n, ok := t.extColor(attr[i+1:])
+			if ok {
+				t.cur.attr.bg = c
 			} else {
 				t.logf("gfx attr %d unknown\n", a)
 			}
+			i += n
 		case 49:
 			t.cur.attr.bg = DefaultBG
 		default:
@@ -657,47 +1120,90 @@ func (t *State) setAttr(attr []int) {
 	}
 }
 
+// extColor parses the arguments following an extended color attribute (38
+// or 48), either 5;n for an xterm color or 2;r;g;b for a true color. It
+// returns the color and the number of arguments consumed.
+func (t *State) extColor(attr []int) (Color, int, bool) {
+	if len(attr) == 0 {
+		return 0, 0, false
+	}
+	switch attr[0] {
+	case 5:
+		if len(attr) < 2 {
+			return 0, len(attr), false
+		}
+		if !between(attr[1], 0, 255) {
+			t.logf("bad color %d\n", attr[1])
+			return 0, 2, false
+		}
+		return Color(attr[1]), 2, true
+	case 2:
+		if len(attr) < 4 {
+			return 0, len(attr), false
+		}
+		r, g, b := attr[1], attr[2], attr[3]
+		if !between(r, 0, 255) || !between(g, 0, 255) || !between(b, 0, 255) {
+			t.logf("bad rgb color %d;%d;%d\n", r, g, b)
+			return 0, 4, false
+		}
+		return RGB(uint8(r), uint8(g), uint8(b)), 4, true
+	}
+	return 0, 0, false
+}
+
 func (t *State) insertBlanks(n int) {
+	if !t.inMargins(t.cur.x) {
+		return
+	}
 	src := t.cur.x
 	dst := src + n
-	size := t.cols - dst
+	size := t.right + 1 - dst
 	t.changed |= ChangedScreen
 	t.dirty[t.cur.y] = true
 
-	if dst >= t.cols {
-		t.clear(t.cur.x, t.cur.y, t.cols-1, t.cur.y)
+	if dst > t.right {
+		t.clear(t.cur.x, t.cur.y, t.right, t.cur.y)
 	} else {
-		copy(t.lines[t.cur.y][dst:dst+size], t.lines[t.cur.y][src:src+size])
+		t.splitWide(src, t.cur.y)
+		t.splitWide(src+size-1, t.cur.y)
+		t.splitWide(t.right, t.cur.y)
+		copyCells(&t.lines[t.cur.y], dst, &t.lines[t.cur.y], src, size)
 		t.clear(src, t.cur.y, dst-1, t.cur.y)
 	}
 }
 
 func (t *State) insertBlankLines(n int) {
-	if t.cur.y < t.top || t.cur.y > t.bottom {
+	if t.cur.y < t.top || t.cur.y > t.bottom || !t.inMargins(t.cur.x) {
 		return
 	}
 	t.scrollDown(t.cur.y, n)
 }
 
 func (t *State) deleteLines(n int) {
-	if t.cur.y < t.top || t.cur.y > t.bottom {
+	if t.cur.y < t.top || t.cur.y > t.bottom || !t.inMargins(t.cur.x) {
 		return
 	}
 	t.scrollUp(t.cur.y, n)
 }
 
 func (t *State) deleteChars(n int) {
+	if !t.inMargins(t.cur.x) {
+		return
+	}
 	src := t.cur.x + n
 	dst := t.cur.x
-	size := t.cols - src
+	size := t.right + 1 - src
 	t.changed |= ChangedScreen
 	t.dirty[t.cur.y] = true
 
-	if src >= t.cols {
-		t.clear(t.cur.x, t.cur.y, t.cols-1, t.cur.y)
+	if src > t.right {
+		t.clear(t.cur.x, t.cur.y, t.right, t.cur.y)
 	} else {
-		copy(t.lines[t.cur.y][dst:dst+size], t.lines[t.cur.y][src:src+size])
-		t.clear(t.cols-n, t.cur.y, t.cols-1, t.cur.y)
+		t.splitWide(dst, t.cur.y)
+		t.splitWide(src, t.cur.y)
+		t.splitWide(t.right, t.cur.y)
+		copyCells(&t.lines[t.cur.y], dst, &t.lines[t.cur.y], src, size)
+		t.clear(t.right-n+1, t.cur.y, t.right, t.cur.y)
 	}
 }
 
@@ -1,29 +1,60 @@
 package terminal
 
 import (
+	"bytes"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
+// maxStrLen bounds the length in bytes of STR sequences, so that an
+// unterminated sequence does not absorb the entire stream into memory.
+const maxStrLen = 1 << 20
+
+// maxITermLen is the larger bound of OSC 1337 sequences, which carry
+// inline image files.
+const maxITermLen = 64 << 20
+
 // STR sequences are similar to CSI sequences, but have string arguments (and
 // as far as I can tell, don't really have a name; STR is the name I took from
 // suckless which I imagine comes from rxvt or xterm).
 type strEscape struct {
 	typ  rune
-	buf  []rune
+	buf  []byte
+	raw  string // buf as parsed into args
 	args []string
+	term string // terminator, repeated in responses
+
+	overflow bool // buf was truncated at maxStrLen
 }
 
 func (s *strEscape) reset() {
 	s.typ = 0
+	s.term = ""
+	if cap(s.buf) > 4096 {
+		// don't hold on to large payloads
+		s.buf = nil
+	}
 	s.buf = s.buf[:0]
+	s.raw = ""
 	s.args = nil
+	s.overflow = false
+}
+
+// limit returns the maximum length of the sequence.
+func (s *strEscape) limit() int {
+	if s.typ == ']' && bytes.HasPrefix(s.buf, []byte("1337;")) {
+		return maxITermLen
+	}
+	return maxStrLen
 }
 
 func (s *strEscape) put(c rune) {
 	// TODO: improve allocs with an array backed slice; bench first
-	if len(s.buf) < 256 {
-		s.buf = append(s.buf, c)
+	if len(s.buf)+utf8.RuneLen(c) <= s.limit() {
+		s.buf = utf8.AppendRune(s.buf, c)
+	} else {
+		s.overflow = true
 	}
 	// Going by st, it is better to remain silent when the STR sequence is not
 	// ended so that it is apparent to users something is wrong. The length sanity
@@ -32,7 +63,17 @@ func (s *strEscape) put(c rune) {
 }
 
 func (s *strEscape) parse() {
-	s.args = strings.Split(string(s.buf), ";")
+	s.raw = string(s.buf)
+	s.args = strings.Split(s.raw, ";")
+}
+
+// argsFrom returns the arguments from i on, as they were sent.
+func (s *strEscape) argsFrom(i int) string {
+	rest := s.raw
+	for ; i > 0; i-- {
+		_, rest, _ = strings.Cut(rest, ";")
+	}
+	return rest
 }
 
 func (s *strEscape) arg(i, def int) int {
@@ -69,10 +110,34 @@ func (t *State) handleSTR() {
 			if len(s.args) < 3 {
 				break
 			}
-			// setcolorname(s.arg(1, 0), s.argString(2, ""))
+			t.setPaletteColors(s.args[1:])
 		case 104: // color reset
-			// TODO: complain about invalid color, redraw, etc.
-			// setcolorname(s.arg(1, 0), nil)
+			t.resetPaletteColors(s.args[1:])
+		case 10, 11, 12: // foreground, background, cursor color set
+			t.setDynamicColors(d, s.args[1:])
+		case 110, 111, 112: // foreground, background, cursor color reset
+			t.resetDynamicColor(d - 100)
+		case 7: // working directory
+			t.setWorkingDirectory(strings.Join(s.args[1:], ";"))
+		case 133: // semantic prompt
+			t.handleSemanticPrompt(s.args[1:])
+		case 8: // hyperlink
+			if len(s.args) < 3 {
+				break
+			}
+			// the URI may itself contain semicolons
+			t.handleHyperlink(s.args[1], strings.Join(s.args[2:], ";"))
+		case 9: // notification or progress
+			t.handleNotify(s.args[1:])
+		case 777: // rxvt extension
+			t.handleRxvtExtension(s.args[1:])
+		case 1337: // iTerm2 extension
+			t.handleITerm(s.argsFrom(1))
+		case 52: // clipboard
+			if len(s.args) < 3 {
+				break
+			}
+			t.handleClipboard(s.args[1], s.args[2])
 		default:
 			t.logf("unknown OSC command %d\n", d)
 			// TODO: s.dump()
@@ -82,13 +147,35 @@ func (t *State) handleSTR() {
 		if title != "" {
 			t.setTitle(title)
 		}
+	case '_': // APC - application program command
+		if strings.HasPrefix(s.raw, "G") {
+			t.handleKittyGraphics(s.raw[1:])
+			break
+		}
+		t.logln("unknown APC sequence")
+	case 'P': // DCS - device control string
+		if strings.HasPrefix(s.raw, "$q") {
+			t.handleDECRQSS(s.raw[2:])
+			break
+		}
+		t.logln("unknown DCS sequence")
 	default:
 		// TODO: Ignore these codes instead of complain?
-		// 'P': // DSC - device control string
-		// '_': // APC - application program command
 		// '^': // PM - privacy message
 
 		t.logf("unhandled STR sequence '%c'\n", s.typ)
 		// t.str.dump()
 	}
 }
+
+// handleDECRQSS reports the setting of a control function, given by its
+// intermediate and final bytes.
+func (t *State) handleDECRQSS(pt string) {
+	switch pt {
+	case " q": // DECSCUSR
+		t.respond("\033P1$r%d q%s", t.cursorStyleParam(), t.str.term)
+	default:
+		t.logf("unsupported DECRQSS '%s'\n", pt)
+		t.respond("\033P0$r%s", t.str.term)
+	}
+}
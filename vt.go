@@ -3,10 +3,13 @@ package terminal
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"github.com/kr/pty"
 	"io"
 	"os"
 	"os/exec"
+	"sync"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -17,6 +20,7 @@ type VT struct {
 	rc   io.ReadCloser
 	br   *bufio.Reader
 	pty  *os.File
+	wmu  sync.Mutex // orders writes of responses
 }
 
 // Start initializes a virtual terminal emulator with the target state
@@ -33,6 +37,7 @@ func Start(state *State, cmd *exec.Cmd) (*VT, *os.File, error) {
 	}
 	t.rc = t.pty
 	t.init()
+	t.dest.w = t.pty
 	return t, t.pty, nil
 }
 
@@ -50,13 +55,45 @@ func Create(state *State, rc io.ReadCloser) (*VT, error) {
 func (t *VT) init() {
 	t.br = bufio.NewReader(t.rc)
 	t.dest.numlock = true
+	t.dest.focused = true
 	t.dest.state = t.dest.parse
 	t.dest.cur.attr.fg = DefaultFG
 	t.dest.cur.attr.bg = DefaultBG
+	t.dest.cellW = defaultCellWidth
+	t.dest.cellH = defaultCellHeight
 	t.Resize(80, 24)
 	t.dest.reset()
 }
 
+// SetResponseWriter sets the writer that receives replies to queries from
+// the application, such as device attributes and cursor position reports.
+// Start sets it to the pty file; with Create there is none until set.
+func (t *VT) SetResponseWriter(w io.Writer) {
+	t.dest.lock()
+	defer t.dest.unlock()
+	t.dest.w = w
+}
+
+// SetFocus records whether the terminal has focus, reporting changes to the
+// application if it enabled focus events.
+func (t *VT) SetFocus(focused bool) error {
+	t.dest.lock()
+	changed := t.dest.focused != focused
+	t.dest.focused = focused
+	report := changed && t.dest.mode&ModeFocus != 0
+	w := t.dest.w
+	t.dest.unlock()
+	if !report || w == nil {
+		return nil
+	}
+	seq := "\033[O"
+	if focused {
+		seq = "\033[I"
+	}
+	_, err := io.WriteString(w, seq)
+	return err
+}
+
 // File returns the pty file.
 func (t *VT) File() *os.File {
 	return t.pty
@@ -66,6 +103,7 @@ func (t *VT) File() *os.File {
 func (t *VT) Write(p []byte) (int, error) {
 	var written int
 	r := bytes.NewReader(p)
+	defer t.flush()
 	t.dest.lock()
 	defer t.dest.unlock()
 	for {
@@ -97,17 +135,29 @@ func (t *VT) Close() error {
 
 // Parse blocks on read on pty or io.ReadCloser, then parses sequences until
 // buffer empties. State is locked as soon as first rune is read, and unlocked
-// when buffer is empty.
+// when buffer is empty. While the application draws a synchronized frame,
+// Parse keeps reading until the frame ends or times out, unlocking the
+// state whenever it waits for more input. This needs an input that supports
+// read deadlines, such as a pty or pipe *os.File; otherwise Parse returns
+// when the buffer empties as usual.
 // TODO: add tests for expected blocking behavior
 func (t *VT) Parse() error {
-	var locked bool
+	var locked, deadline bool
+	defer t.flush()
 	defer func() {
 		if locked {
 			t.dest.unlock()
 		}
+		if deadline {
+			t.setReadDeadline(time.Time{})
+		}
 	}()
 	for {
 		c, sz, err := t.br.ReadRune()
+		if deadline && errors.Is(err, os.ErrDeadlineExceeded) {
+			// the frame timed out
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -127,12 +177,46 @@ func (t *VT) Parse() error {
 		// incomplete rune.
 		n := t.br.Buffered()
 		if n == 0 || (n < 4 && !fullRuneBuffered(t.br)) {
-			break
+			// wait for the rest of the frame, if the input can stop
+			// waiting when it times out
+			if !t.dest.syncing() || !t.setReadDeadline(t.dest.syncStart.Add(syncTimeout)) {
+				break
+			}
+			deadline = true
+			t.dest.unlock()
+			locked = false
+			t.flush()
 		}
 	}
 	return nil
 }
 
+// setReadDeadline sets the deadline for reading the input, returning false
+// if the input does not support deadlines.
+func (t *VT) setReadDeadline(d time.Time) bool {
+	rd, ok := t.rc.(interface {
+		SetReadDeadline(time.Time) error
+	})
+	return ok && rd.SetReadDeadline(d) == nil
+}
+
+// flush writes the responses queued while parsing. It must be called with
+// the state unlocked.
+func (t *VT) flush() {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	t.dest.lock()
+	out, w := t.dest.out, t.dest.w
+	t.dest.out = nil
+	t.dest.unlock()
+	if len(out) == 0 || w == nil {
+		return
+	}
+	if _, err := w.Write(out); err != nil {
+		t.dest.logf("response write failed: %v\n", err)
+	}
+}
+
 func fullRuneBuffered(br *bufio.Reader) bool {
 	n := br.Buffered()
 	buf, err := br.Peek(n)
@@ -142,6 +226,19 @@ func fullRuneBuffered(br *bufio.Reader) bool {
 	return utf8.FullRune(buf)
 }
 
+// SetCellSize sets the size of a cell in pixels, which determines how many
+// cells images cover and is reported to the pty.
+func (t *VT) SetCellSize(w, h int) {
+	if w < 1 || h < 1 {
+		return
+	}
+	t.dest.lock()
+	defer t.dest.unlock()
+	t.dest.cellW = w
+	t.dest.cellH = h
+	t.ptyResize()
+}
+
 // Resize reports new size to pty and updates state.
 func (t *VT) Resize(cols, rows int) {
 	t.dest.lock()
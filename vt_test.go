@@ -1,9 +1,12 @@
 package terminal
 
 import (
+	"bytes"
 	"io"
+	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func extractStr(t *State, x0, x1, row int) string {
@@ -68,3 +71,406 @@ func TestNewline(t *testing.T) {
 		t.Fatal(st.cur.x, st.cur.y, fg, bg)
 	}
 }
+
+func TestTrueColor(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = term.Write([]byte("\033[38;2;10;20;30;48;5;200ma\033[38;5;3;48;2;255;0;128mb"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	_, fg, bg := st.Cell(0, 0)
+	if !fg.TrueColor() || fg != RGB(10, 20, 30) || bg != 200 || !bg.Indexed() {
+		t.Fatal(fg, bg)
+	}
+	if r, g, b := fg.RGB(); r != 10 || g != 20 || b != 30 {
+		t.Fatal(r, g, b)
+	}
+	_, fg, bg = st.Cell(1, 0)
+	if fg != Yellow || bg != RGB(255, 0, 128) || fg.TrueColor() {
+		t.Fatal(fg, bg)
+	}
+}
+
+// blockingWriter is a response writer nobody reads from.
+type blockingWriter struct {
+	entered, release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.entered <- struct{}{}
+	<-w.release
+	return len(p), nil
+}
+
+func TestBlockedResponseWriter(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &blockingWriter{make(chan struct{}), make(chan struct{})}
+	term.SetResponseWriter(w)
+	go term.Write([]byte("\033[c"))
+	<-w.entered
+
+	// the state can be used while the response is stuck
+	locked := make(chan struct{})
+	go func() {
+		st.Lock()
+		st.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("state blocked by response writer")
+	}
+	close(w.release)
+}
+
+func TestInsertMode(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.Resize(8, 2)
+	_, err = term.Write([]byte("abcdefgh\r\033[4hXY\033[4lZ"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	expected := "XYZbcdef"
+	actual := extractStr(&st, 0, 7, 0)
+	if expected != actual {
+		t.Fatal(actual)
+	}
+	if st.Mode(ModeInsert) {
+		t.Fatal("insert mode still set")
+	}
+}
+
+func TestDeviceReports(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	term.SetResponseWriter(&buf)
+	tests := []struct {
+		in, out string
+	}{
+		{"\033[c", deviceAttrs},
+		{"\033[0c", deviceAttrs},
+		{"\033Z", deviceAttrs},
+		{"\033[>c", "\033[>1;0;0c"},
+		{"\033[5n", "\033[0n"},
+		{"\033[3;7H\033[6n", "\033[3;7R"},
+		{"\033[?6n", "\033[?3;7;1R"},
+		{"\033[2;10r\033[?6h\033[2;4H\033[6n", "\033[2;4R"},
+	}
+	for _, test := range tests {
+		buf.Reset()
+		_, err = term.Write([]byte(test.in))
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if buf.String() != test.out {
+			t.Fatalf("%q: got %q, expected %q", test.in, buf.String(), test.out)
+		}
+	}
+}
+
+func TestWideChars(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.Resize(5, 3)
+	_, err = term.Write([]byte("a世界b"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if actual := extractStr(&st, 0, 4, 0); actual != "a世\x00界\x00" {
+		t.Fatalf("%q", actual)
+	}
+	if c, _, _ := st.Cell(0, 1); c != 'b' {
+		t.Fatalf("%q", c)
+	}
+
+	// a wide character does not fit in the last column
+	_, err = term.Write([]byte("\033[2;1Habcd世"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if actual := extractStr(&st, 0, 4, 1) + extractStr(&st, 0, 1, 2); actual != "abcd 世\x00" {
+		t.Fatalf("%q", actual)
+	}
+	if x, y := st.Cursor(); x != 2 || y != 2 {
+		t.Fatal(x, y)
+	}
+
+	// overwriting either half erases the whole character
+	_, err = term.Write([]byte("\033[1;3Hx\033[1;5H\033[X"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if actual := extractStr(&st, 0, 4, 0); actual != "a x  " {
+		t.Fatalf("%q", actual)
+	}
+}
+
+func TestSplitWide(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.Resize(5, 2)
+	// combining marks go with the wide character they are on
+	_, err = term.Write([]byte("\u304b\u3099\u304b\u3099\033[1;2Hx\033[1;3H\033[X"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	for x, expected := range []string{" ", "x", " ", " "} {
+		if actual := st.CellString(x, 0); actual != expected {
+			t.Fatalf("%d: %q", x, actual)
+		}
+	}
+
+	// a wide character missing its second half is blanked alone
+	st.lines[1].cells[1] = glyph{c: '\u4e16', mode: attrWide}
+	st.lines[1].cells[2] = glyph{c: 'z'}
+	st.splitWide(1, 1)
+	if actual := extractStr(&st, 1, 2, 1); actual != " z" {
+		t.Fatalf("%q", actual)
+	}
+	if st.lines[1].cells[1].mode&attrWide != 0 {
+		t.Fatal("wide attribute kept")
+	}
+}
+
+func TestCombiningChars(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = term.Write([]byte("e\u0301x\U0001F468\u200d\U0001F469\U0001F1EF\U0001F1F5!"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	expected := []string{"e\u0301", "x", "\U0001F468\u200d\U0001F469", "", "\U0001F1EF\U0001F1F5", "!"}
+	for i, s := range expected {
+		if actual := st.CellString(i, 0); actual != s {
+			t.Fatalf("%d: %q", i, actual)
+		}
+	}
+	if x, _ := st.Cursor(); x != len(expected) {
+		t.Fatal(x)
+	}
+}
+
+func TestCharsets(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// G1 line drawing via SO/SI, G2 UK via SS2, G3 technical via LS3, and
+	// DEC supplemental in G0
+	_, err = term.Write([]byte("\033)0q\016q\017q\033*A\033N##\033+>\033oD\033(%5\017a"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	expected := "q─q£#Δá"
+	actual := extractStr(&st, 0, len([]rune(expected))-1, 0)
+	if expected != actual {
+		t.Fatal(actual)
+	}
+}
+
+func TestLeftRightMargins(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term.Resize(8, 3)
+	_, err = term.Write([]byte("abcdefgh\r\nijklmnop\r\nqrstuvwx"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	// margins at columns 3-6, then scroll up, delete a char, and print
+	// past the right margin so it wraps and scrolls within the margins
+	_, err = term.Write([]byte("\033[?69h\033[3;6s\033[S\033[1;4H\033[P\033[3;5H123"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"abstuvgh",
+		"ij  12op",
+		"qr3   wx",
+	}
+	for y, line := range expected {
+		if actual := extractStr(&st, 0, 7, y); actual != line {
+			t.Fatal(y, actual)
+		}
+	}
+	if x, y := st.Cursor(); x != 3 || y != 2 {
+		t.Fatal(x, y)
+	}
+
+	// XTSAVE is not taken for DECSLRM
+	_, err = term.Write([]byte("\033[?2004s"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if x, y := st.Cursor(); x != 3 || y != 2 || st.left != 2 || st.right != 5 {
+		t.Fatal(x, y, st.left, st.right)
+	}
+}
+
+func TestFocus(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	term.SetResponseWriter(&buf)
+	if !st.Focused() {
+		t.Fatal("not focused initially")
+	}
+	term.SetFocus(false)
+	_, err = term.Write([]byte("\033[?1004h"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	term.SetFocus(true)
+	term.SetFocus(true)
+	term.SetFocus(false)
+	if st.Focused() {
+		t.Fatal("focused")
+	}
+	if buf.String() != "\033[I\033[O" {
+		t.Fatalf("%q", buf.String())
+	}
+}
+
+func TestCursorStyle(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	term.SetResponseWriter(&buf)
+	if shape, blink := st.CursorStyle(); shape != CursorBlock || blink {
+		t.Fatal(shape, blink)
+	}
+	_, err = term.Write([]byte("\033[6 q\033P$q q\033\\\033[?12h"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if shape, blink := st.CursorStyle(); shape != CursorBar || !blink {
+		t.Fatal(shape, blink)
+	}
+	_, err = term.Write([]byte("\033P$q q\033\\\033P$qx\033\\\033[4h\033[!p"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if want := "\033P1$r6 q\033\\\033P1$r5 q\033\\\033P0$r\033\\"; buf.String() != want {
+		t.Fatalf("%q", buf.String())
+	}
+	if shape, blink := st.CursorStyle(); shape != CursorBlock || blink || st.Mode(ModeInsert) {
+		t.Fatal(shape, blink)
+	}
+	_, err = term.Write([]byte("\033[4 q\033c"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if shape, _ := st.CursorStyle(); shape != CursorBlock {
+		t.Fatal(shape)
+	}
+}
+
+func TestSyncOutput(t *testing.T) {
+	defer func(d time.Duration) { syncTimeout = d }(syncTimeout)
+	syncTimeout = 100 * time.Millisecond
+
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	term.SetResponseWriter(&buf)
+	_, err = term.Write([]byte("\033[?2026$p\033[?2026hx\033[?2026$p\033[4$p\033[?9999$p"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if want := "\033[?2026;2$y\033[?2026;1$y\033[4;2$y\033[?9999;0$y"; buf.String() != want {
+		t.Fatalf("%q", buf.String())
+	}
+	st.Lock()
+	st.Unlock()
+	if st.Changed(ChangedScreen) {
+		t.Fatal("changes reported mid-frame")
+	}
+	st.syncStart = time.Now().Add(-syncTimeout)
+	if !st.Changed(ChangedScreen) {
+		t.Fatal("changes held back after timeout")
+	}
+	_, err = term.Write([]byte("\033[?2026l"))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !st.Changed(ChangedScreen) {
+		t.Fatal("changes not reported after frame")
+	}
+
+	// Parse returns only once the frame is complete
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	term, err = Create(&st, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.Write([]byte("\033[?2026ha"))
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("b\033[?2026l"))
+	}()
+	if err := term.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if s := extractStr(&st, 0, 1, 0); s != "ab" {
+		t.Fatal(s)
+	}
+
+	// or once the frame times out
+	start := time.Now()
+	w.Write([]byte("\033[?2026hcd"))
+	if err := term.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < syncTimeout || d > 2*syncTimeout {
+		t.Fatal(d)
+	}
+	if s := extractStr(&st, 0, 3, 0); s != "abcd" {
+		t.Fatal(s)
+	}
+	if !st.Changed(ChangedScreen) {
+		t.Fatal("changes held back after timeout")
+	}
+}